@@ -1,28 +1,98 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"log"
 	"net/http"
+	"time"
 )
 
 func main() {
-	go internal.FileDeleter()
+	configPath := flag.String("config", "config.json", "path to config file")
+	flag.Parse()
 
-	// Создаем лимиты на 3 слота
-	limiter := internal.NewRateLimiter(3)
-	limiterdownload := internal.NewRateLimiter(3)
+	cfg, err := internal.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Error read config: %v", err)
+	}
+
+	storage := internal.NewStorage(cfg.Dir, time.Duration(cfg.DefaultTTL))
+	storage.SetQuota(cfg.Quota)
+
+	// Чистим временные файлы, если прошлый запуск упал во время записи
+	if err := storage.Recover(); err != nil {
+		log.Printf("Error recover storage: %v", err)
+	}
+
+	// Уборщик удаляет устаревшие архивы
+	janitor := internal.NewJanitor(storage, time.Duration(cfg.SweepInterval), cfg.AdminToken)
+	go janitor.Run()
+
+	// Очередь задач на сборку архивов и лимит одновременных скачиваний
+	queue := internal.NewQueue(cfg.Workers, cfg.QueueSize)
+	limiterdownload := internal.NewRateLimiter(cfg.Downloads.Slots)
+	limiterdownload.SetPriorities(cfg.Downloads.Reserved, time.Duration(cfg.Downloads.Aging))
+	queue.SetPriorities(cfg.Reserved, time.Duration(cfg.Downloads.Aging))
+	limiterdownload.SetHosts(cfg.Downloads.PerHost, time.Duration(cfg.Downloads.HostDelay), cfg.Downloads.Hosts)
+
+	// Журнал задач, по нему после перезапуска продолжаем незавершённые задачи
+	var tasks []*internal.Task
+	if cfg.Journal != "" {
+		journal, err := internal.OpenJournal(cfg.Journal)
+		if err != nil {
+			log.Fatalf("Error open journal: %v", err)
+		}
+		defer journal.Close()
+
+		tasks, err = journal.Replay()
+		if err != nil {
+			log.Fatalf("Error read journal: %v", err)
+		}
+		queue.SetJournal(journal)
+	}
 
 	// Настраиваем маршруты
-	downloadHandler := internal.NewHandler(limiter, limiterdownload)
+	downloadHandler := internal.NewHandler(queue, limiterdownload)
+	downloadHandler.SetStorage(storage)
+	downloadHandler.SetNotifier(internal.NewNotifier(cfg.Webhook))
+	downloadHandler.SetCompression(cfg.Compression)
+	if err := downloadHandler.SetDownloads(cfg.Downloads); err != nil {
+		log.Fatalf("Error downloads config: %v", err)
+	}
+	downloadHandler.SetS3(cfg.S3)
+	queue.Restore(tasks)
+
+	// завершённые задачи со временем забываются, журнал сжимается без них
+	queue.SetRetention(time.Duration(cfg.TaskRetention))
+	go queue.RunPruner(time.Duration(cfg.SweepInterval))
+
+	// повтор изменяющего запроса с тем же Idempotency-Key получает первый ответ
+	idempotency := internal.NewIdempotency(time.Duration(cfg.IdempotencyWindow))
 
 	http.HandleFunc("/downloadandzip", downloadHandler.DownloadAndZip)
-	http.HandleFunc("/createzip", downloadHandler.CreateZip)
-	http.HandleFunc("/addtozip", downloadHandler.AddToZip)
+	http.HandleFunc("/createzip", idempotency.Wrap(downloadHandler.CreateZip))
+	http.HandleFunc("/addtozip", idempotency.Wrap(downloadHandler.AddToZip))
 	http.HandleFunc("/downloadzip", downloadHandler.DownloadZip)
 	http.HandleFunc("/downloadzipanddelete", downloadHandler.DownloadZipAndDelete)
+	http.HandleFunc("GET /archives/{name}", downloadHandler.GetArchive)
+	http.HandleFunc("GET /archives/{name}/entries", downloadHandler.ListEntries)
+	http.HandleFunc("GET /archives/{name}/entries/{path...}", downloadHandler.GetEntry)
+	http.HandleFunc("DELETE /archives/{name}/entries/{path...}", idempotency.Wrap(downloadHandler.DeleteEntry))
+	http.HandleFunc("POST /archives/{name}/move", idempotency.Wrap(downloadHandler.MoveEntry))
+	http.HandleFunc("POST /archives/{name}/pin", idempotency.Wrap(downloadHandler.PinArchive))
+	http.HandleFunc("DELETE /archives/{name}/pin", idempotency.Wrap(downloadHandler.UnpinArchive))
+	http.HandleFunc("/admin/sweep", janitor.HandleSweep)
+	http.HandleFunc("GET /usage", downloadHandler.GetUsage)
+	http.HandleFunc("GET /tasks/{id}", downloadHandler.TaskStatus)
+	http.HandleFunc("GET /tasks/{id}/results", downloadHandler.TaskResults)
+	http.HandleFunc("GET /tasks/{id}/deliveries", downloadHandler.TaskDeliveries)
+	http.HandleFunc("GET /tasks/{id}/events", downloadHandler.TaskEvents)
+	http.HandleFunc("DELETE /tasks/{id}", idempotency.Wrap(downloadHandler.CancelTask))
+	http.HandleFunc("POST /tasks/{id}/cancel", idempotency.Wrap(downloadHandler.CancelTask))
 
 	// Запускаем сервер
 	fmt.Println("Server Started")
-	http.ListenAndServe(":8080", nil)
+	http.ListenAndServe(cfg.Addr, nil)
 }
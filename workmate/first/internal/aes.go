@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// заголовок с паролем для чтения зашифрованных файлов архива
+const PasswordHeader = "X-Archive-Password"
+
+var (
+	ErrWrongPassword = errors.New("wrong password")
+	ErrAuthFailed    = errors.New("entry authentication failed")
+	ErrNotEncrypted  = errors.New("entry is not encrypted")
+)
+
+// Шифрование WinZip AES (AE-2): метод 99, в extra поле 0x9901 лежит настоящий метод сжатия.
+// Данные файла: соль, 2 байта проверки пароля, AES-CTR(сжатые данные), 10 байт HMAC-SHA1
+const (
+	methodAES    = 99
+	extraAES     = 0x9901
+	aesStrength  = 3 // AES-256
+	aesKeySize   = 32
+	aesSaltSize  = 16
+	aesIter      = 1000
+	aesAuthSize  = 10
+	aesVersionAE = 2 // AE-2: CRC не пишется, целостность проверяет HMAC
+)
+
+// Сжимаем и шифруем файл, пишем его в архив готовыми байтами.
+// Сжатые данные копятся во временном файле в dir: размер нужно знать до записи заголовка
+func writeEncrypted(zipWriter *zip.Writer, name string, method uint16, level int, modified time.Time, r io.Reader, size int64, password string, dir string) error {
+	data, dataSize := r, size
+	if method == zip.Deflate {
+		tmp, err := os.CreateTemp(dir, ".aes"+tmpMarker+"*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		fw, err := flate.NewWriter(tmp, level)
+		if err != nil {
+			return err
+		}
+		if size, err = io.Copy(fw, r); err != nil {
+			return err
+		}
+		if err := fw.Close(); err != nil {
+			return err
+		}
+		if dataSize, err = tmp.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data = tmp
+	}
+
+	salt := make([]byte, aesSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	encKey, authKey, verifier := aesKeys(password, salt, aesKeySize)
+	stream, err := newAESCTR(encKey)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha1.New, authKey)
+
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], extraAES)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], aesVersionAE)
+	copy(extra[6:], "AE")
+	extra[8] = aesStrength
+	binary.LittleEndian.PutUint16(extra[9:], method)
+
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             methodAES,
+		Flags:              0x1, // файл зашифрован
+		Extra:              extra,
+		CreatorVersion:     51,
+		ReaderVersion:      51,
+		CompressedSize64:   uint64(int64(len(salt)+len(verifier)+aesAuthSize) + dataSize),
+		UncompressedSize64: uint64(size),
+	}
+	header.ModifiedTime, header.ModifiedDate = msDosTime(modified)
+	if !isASCII(name) {
+		header.Flags |= 0x800 // имя в UTF-8
+	}
+
+	writer, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(append(salt, verifier...)); err != nil {
+		return err
+	}
+
+	// шифруем кусками, HMAC считается по зашифрованным данным
+	encrypted := cipher.StreamWriter{S: stream, W: io.MultiWriter(writer, mac)}
+	written, err := io.Copy(encrypted, data)
+	if err != nil {
+		return err
+	}
+	if written != dataSize {
+		return fmt.Errorf("entry size changed: %d bytes instead of %d", written, dataSize)
+	}
+
+	_, err = writer.Write(mac.Sum(nil)[:aesAuthSize])
+	return err
+}
+
+// Открываем зашифрованный файл архива на чтение. Зашифрованные данные сначала копируются
+// во временный файл в dir и проверяются по HMAC, расшифровка и распаковка идут уже при чтении.
+// Временный файл удаляется при Close
+func OpenEncrypted(f *zip.File, password string, dir string) (io.ReadCloser, error) {
+	if f.Method != methodAES {
+		return nil, ErrNotEncrypted
+	}
+
+	strength, method, ok := parseAESExtra(f.Extra)
+	if !ok || strength < 1 || strength > 3 || (method != zip.Store && method != zip.Deflate) {
+		return nil, zip.ErrAlgorithm
+	}
+	keySize := 8 + 8*int(strength) // 16, 24 или 32 байта
+	saltSize := keySize / 2
+
+	dataSize := int64(f.CompressedSize64) - int64(saltSize+2+aesAuthSize)
+	if dataSize < 0 {
+		return nil, zip.ErrFormat
+	}
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, saltSize+2)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+	encKey, authKey, want := aesKeys(password, header[:saltSize], keySize)
+	if subtle.ConstantTimeCompare(header[saltSize:], want) != 1 {
+		return nil, ErrWrongPassword
+	}
+
+	tmp, err := os.CreateTemp(dir, ".aes"+tmpMarker+"*")
+	if err != nil {
+		return nil, err
+	}
+	entry := &decryptedEntry{file: tmp}
+	fail := func(err error) (io.ReadCloser, error) {
+		entry.Close()
+		return nil, err
+	}
+
+	// HMAC считается по зашифрованным данным, пока они копируются на диск
+	mac := hmac.New(sha1.New, authKey)
+	if _, err := io.CopyN(io.MultiWriter(tmp, mac), raw, dataSize); err != nil {
+		return fail(err)
+	}
+	auth := make([]byte, aesAuthSize)
+	if _, err := io.ReadFull(raw, auth); err != nil {
+		return fail(err)
+	}
+	if !hmac.Equal(mac.Sum(nil)[:aesAuthSize], auth) {
+		return fail(ErrAuthFailed)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+
+	stream, err := newAESCTR(encKey)
+	if err != nil {
+		return fail(err)
+	}
+	entry.Reader = cipher.StreamReader{S: stream, R: tmp}
+	if method == zip.Deflate {
+		entry.Reader = flate.NewReader(entry.Reader)
+	}
+	return entry, nil
+}
+
+// Расшифровываем файл архива целиком в память, подходит для небольших файлов вроде манифеста
+func DecryptEntry(f *zip.File, password string) ([]byte, error) {
+	rc, err := OpenEncrypted(f, password, "")
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Расшифрованный файл: читается из временного файла с проверенными данными
+type decryptedEntry struct {
+	io.Reader
+	file *os.File
+}
+
+func (e *decryptedEntry) Close() error {
+	e.file.Close()
+	return os.Remove(e.file.Name())
+}
+
+// Ищем в extra поле AES: сила шифра и настоящий метод сжатия
+func parseAESExtra(extra []byte) (byte, uint16, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return 0, 0, false
+		}
+		if id == extraAES && size >= 7 {
+			return extra[8], binary.LittleEndian.Uint16(extra[9:]), true
+		}
+		extra = extra[4+size:]
+	}
+	return 0, 0, false
+}
+
+// Ключ шифрования, ключ HMAC и 2 байта проверки пароля из PBKDF2-HMAC-SHA1
+func aesKeys(password string, salt []byte, keySize int) ([]byte, []byte, []byte) {
+	key := pbkdf2([]byte(password), salt, aesIter, 2*keySize+2, sha1.New)
+	return key[:keySize], key[keySize : 2*keySize], key[2*keySize:]
+}
+
+// Поток AES-CTR как в WinZip: счётчик little-endian и начинается с 1
+type winzipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int // сколько байт текущего блока потока уже использовано
+}
+
+func newAESCTR(key []byte) (*winzipCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &winzipCTR{block: block, used: aes.BlockSize}, nil
+}
+
+func (c *winzipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// PBKDF2 (RFC 8018)
+func pbkdf2(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+
+		t := append([]byte{}, u...)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// Хэш пароля архива для метаданных, по нему проверяем пароль при добавлении файлов
+func hashPassword(password string, salt []byte) string {
+	return hex.EncodeToString(pbkdf2([]byte(password), salt, 10000, 32, sha256.New))
+}
+
+// Время в формате MS-DOS для заголовка zip
+func msDosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	fTime := uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1)
+	fDate := uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+	return fTime, fDate
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
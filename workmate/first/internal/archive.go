@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEntryNotFound = errors.New("entry not found")
+	ErrEntryExists   = errors.New("entry already exists")
+)
+
+// Переписываем архив: fn для каждого файла возвращает новое имя и нужно ли его оставить,
+// add дописывает новые файлы в конец. Файлы копируются без перепаковки,
+// новый архив подменяет старый через rename
+func rewriteZip(filename string, fn func(f *zip.File) (string, bool), add func(zipWriter *zip.Writer) error) error {
+	var files []*zip.File
+
+	// пустой файл считаем пустым архивом
+	if info, err := os.Stat(filename); err != nil {
+		return err
+	} else if info.Size() > 0 {
+		reader, err := zip.OpenReader(filename)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		files = reader.File
+	}
+
+	return writeAtomic(filename, func(w io.Writer) error {
+		zipWriter := zip.NewWriter(w)
+		for _, f := range files {
+			name, keep := f.Name, true
+			if fn != nil {
+				name, keep = fn(f)
+			}
+			if !keep {
+				continue
+			}
+
+			header := f.FileHeader
+			header.Name = name
+
+			writer, err := zipWriter.CreateRaw(&header)
+			if err != nil {
+				return err
+			}
+
+			raw, err := f.OpenRaw()
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(writer, raw); err != nil {
+				return err
+			}
+		}
+
+		if add != nil {
+			if err := add(zipWriter); err != nil {
+				return err
+			}
+		}
+		return zipWriter.Close()
+	})
+}
+
+// Дописываем файлы в архив
+func appendZip(filename string, add func(zipWriter *zip.Writer) error) error {
+	return rewriteZip(filename, nil, add)
+}
+
+// Создаём пустой архив
+func createEmptyZip(filename string) error {
+	return writeAtomic(filename, func(w io.Writer) error {
+		return zip.NewWriter(w).Close()
+	})
+}
+
+// Удаляем файл из архива
+func deleteEntry(filename, name string, compression Compression, password string) error {
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	source := findEntry(&reader.Reader, name)
+	reader.Close()
+
+	if source == nil {
+		return ErrEntryNotFound
+	}
+	return rewriteEntries(filename, name, "", compression, password)
+}
+
+// Переименовываем или перемещаем файл внутри архива
+func moveEntry(filename, from, to string, compression Compression, password string) error {
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	source := findEntry(&reader.Reader, from)
+	target := findEntry(&reader.Reader, to)
+	reader.Close()
+
+	if source == nil {
+		return ErrEntryNotFound
+	}
+	if target != nil {
+		return ErrEntryExists
+	}
+	return rewriteEntries(filename, from, to, compression, password)
+}
+
+// Переписываем архив с переименованным (пустое to - удалённым) файлом.
+// Если в архиве ведётся манифест, manifest.json и SHA256SUMS пишутся заново,
+// зашифрованный манифест читается и пишется с password
+func rewriteEntries(filename, from, to string, compression Compression, password string) error {
+	manifest, hasManifest, err := readManifest(filename, password)
+	if err != nil {
+		return err
+	}
+	// сами служебные файлы меняются как обычные
+	hasManifest = hasManifest && !isManifestEntry(from)
+
+	var add func(zipWriter *zip.Writer) error
+	if hasManifest {
+		manifest.rename(from, to, time.Now())
+		add = func(zipWriter *zip.Writer) error {
+			return manifest.write(newZipArchive(zipWriter, compression, password, filepath.Dir(filename)))
+		}
+	}
+
+	return rewriteZip(filename, func(f *zip.File) (string, bool) {
+		switch {
+		case f.Name == from:
+			return to, to != ""
+		case hasManifest && isManifestEntry(f.Name):
+			return "", false
+		}
+		return f.Name, true
+	}, add)
+}
+
+// путь внутри архива должен быть относительным и без выхода наверх
+func validEntryName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return false
+	}
+	return path.Clean(name) == name && name != ".." && !strings.HasPrefix(name, "../")
+}
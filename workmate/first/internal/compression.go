@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// как сжимать файлы в архиве
+const (
+	CompressionAuto    = "auto"    // уже сжатые типы без сжатия, остальные deflate
+	CompressionStore   = "store"   // ничего не сжимаем
+	CompressionDeflate = "deflate" // сжимаем всё
+)
+
+// Настройки сжатия
+type CompressionConfig struct {
+	Level int      `json:"level"` // уровень deflate от 1 до 9, 0 - по умолчанию
+	Store []string `json:"store"` // расширения уже сжатых файлов, их пишем без сжатия
+}
+
+// уже сжатые форматы, сжимать их ещё раз - только тратить процессор
+var defaultStore = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".zip", ".gz", ".mp4", ".mp3"}
+
+// Политика сжатия для одного архива
+type Compression struct {
+	Mode  string
+	Level int
+	store map[string]bool
+}
+
+// Политика по умолчанию из настроек
+func NewCompression(cfg CompressionConfig) Compression {
+	if cfg.Store == nil {
+		cfg.Store = defaultStore
+	}
+	store := make(map[string]bool)
+	for _, ext := range cfg.Store {
+		store[strings.ToLower(ext)] = true
+	}
+	return Compression{Mode: CompressionAuto, Level: cfg.Level, store: store}
+}
+
+// Меняем политику по параметрам запроса, пустые значения не меняют ничего
+func (c Compression) Override(mode string, level int) (Compression, bool) {
+	switch mode {
+	case "":
+	case CompressionAuto, CompressionStore, CompressionDeflate:
+		c.Mode = mode
+	default:
+		return c, false
+	}
+
+	if level < 0 || level > 9 {
+		return c, false
+	}
+	if level != 0 {
+		c.Level = level
+	}
+	return c, true
+}
+
+// Метод сжатия файла в zip
+func (c Compression) method(name string) uint16 {
+	switch c.Mode {
+	case CompressionStore:
+		return zip.Store
+	case CompressionDeflate:
+		return zip.Deflate
+	}
+	if c.store[strings.ToLower(filepath.Ext(name))] {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// уровень для flate и gzip
+func (c Compression) level() int {
+	if c.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return c.Level
+}
+
+// Подключаем к zip архиву deflate с уровнем из политики
+func (c Compression) register(zipWriter *zip.Writer) {
+	level := c.level()
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// Длительность, которая в json пишется строкой вида "2h" или "30m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Настройки сервера
+type Config struct {
+	Addr          string            `json:"addr"`
+	Dir           string            `json:"dir"`            // папка с архивами
+	DefaultTTL    Duration          `json:"default_ttl"`    // сколько живёт архив без изменений
+	SweepInterval Duration          `json:"sweep_interval"` // как часто проверяем устаревшие архивы
+	AdminToken    string            `json:"admin_token"`    // токен для /admin ручек, пустой - ручки выключены
+	Quota         QuotaConfig       `json:"quota"`
+	Workers       int               `json:"workers"`        // сколько задач собирается одновременно
+	QueueSize     int               `json:"queue_size"`     // сколько задач может ждать в очереди
+	Reserved      int               `json:"reserved"`       // сколько воркеров берут только interactive задачи
+	Journal       string            `json:"journal"`        // файл журнала задач, пустой - без журнала
+	TaskRetention Duration          `json:"task_retention"` // сколько помним завершённые задачи
+	Webhook       WebhookConfig     `json:"webhook"`
+	Downloads     DownloadsConfig   `json:"downloads"`
+	Compression   CompressionConfig `json:"compression"`
+	S3            S3Config          `json:"s3"` // хранилище для ссылок s3://
+
+	IdempotencyWindow Duration `json:"idempotency_window"` // сколько помним ответы на запросы с Idempotency-Key
+}
+
+// Настройки по умолчанию
+func DefaultConfig() Config {
+	return Config{
+		Addr:          ":8080",
+		Dir:           "./",
+		DefaultTTL:    Duration(2 * time.Hour),
+		SweepInterval: Duration(time.Minute),
+		Quota:         QuotaConfig{Policy: QuotaReject},
+		Workers:       3,
+		QueueSize:     10,
+		Reserved:      1,
+		Journal:       "tasks.jsonl",
+		TaskRetention: Duration(24 * time.Hour),
+		Webhook:       WebhookConfig{Attempts: 5, Backoff: Duration(time.Second)},
+		Downloads: DownloadsConfig{
+			Slots:     3,
+			Reserved:  1,
+			Aging:     Duration(30 * time.Second),
+			Redirects: RedirectPolicy{MaxHops: 10},
+		},
+		Compression: CompressionConfig{Store: defaultStore},
+
+		IdempotencyWindow: Duration(24 * time.Hour),
+	}
+}
+
+// Читаем настройки из файла, если файла нет - остаются значения по умолчанию
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+)
+
+// Список файлов в архиве
+func (h *Handler) ListEntries(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validName(name) {
+		http.Error(w, "Error file name", http.StatusBadRequest)
+		return
+	}
+
+	// открываем архив на чтение
+	reader, err := zip.OpenReader(h.storage.Path(zipName(name)))
+	if err != nil {
+		http.Error(w, "Error not such file", http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
+	h.storage.Access(zipName(name))
+
+	entries := make([]EntryInfo, 0, len(reader.File))
+	for _, f := range reader.File {
+		entries = append(entries, EntryInfo{
+			Name:           f.Name,
+			Size:           f.UncompressedSize64,
+			CompressedSize: f.CompressedSize64,
+			CRC32:          f.CRC32,
+			Modified:       f.Modified,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// Отдаём один распакованный файл из архива
+func (h *Handler) GetEntry(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validName(name) {
+		http.Error(w, "Error file name", http.StatusBadRequest)
+		return
+	}
+
+	reader, err := zip.OpenReader(h.storage.Path(zipName(name)))
+	if err != nil {
+		http.Error(w, "Error not such file", http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
+	h.storage.Access(zipName(name))
+
+	entry := findEntry(&reader.Reader, r.PathValue("path"))
+	if entry == nil {
+		http.Error(w, "Error not such entry", http.StatusNotFound)
+		return
+	}
+
+	var rc io.ReadCloser
+	if entry.Method == methodAES {
+		// зашифрованный файл отдаём только с паролем из заголовка
+		rc, err = OpenEncrypted(entry, r.Header.Get(PasswordHeader), h.storage.dir)
+		if errors.Is(err, ErrWrongPassword) || errors.Is(err, ErrAuthFailed) {
+			http.Error(w, "Error password", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error open entry", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		rc, err = entry.Open()
+		if err != nil {
+			http.Error(w, "Error open entry", http.StatusInternalServerError)
+			return
+		}
+	}
+	defer rc.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(entry.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(path.Base(entry.Name)))
+	w.Header().Set("Content-Length", fmt.Sprint(entry.UncompressedSize64))
+
+	// Распаковываем потоком, в память файл целиком не читаем
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Printf("Entry download interrupted: %v", err)
+	}
+}
+
+// ищем файл в архиве по имени
+func findEntry(reader *zip.Reader, name string) *zip.File {
+	for _, f := range reader.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Удаляем файл из архива
+func (h *Handler) DeleteEntry(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validName(name) {
+		http.Error(w, "Error file name", http.StatusBadRequest)
+		return
+	}
+
+	filename := zipName(name)
+	if _, err := os.Stat(h.storage.Path(filename)); err != nil {
+		http.Error(w, "Error not such file", http.StatusNotFound)
+		return
+	}
+
+	unlock := h.storage.Lock(filename)
+	defer unlock()
+
+	password, ok := h.archivePassword(filename, r)
+	if !ok {
+		http.Error(w, "Error password", http.StatusForbidden)
+		return
+	}
+
+	err := deleteEntry(h.storage.Path(filename), r.PathValue("path"), h.compression, password)
+	if errors.Is(err, ErrEntryNotFound) {
+		http.Error(w, "Error not such entry", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Delete entry failed: %v", err)
+		http.Error(w, "Error rewrite zip", http.StatusInternalServerError)
+		return
+	}
+	h.storage.Touch(filename)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Переименовываем или перемещаем файл внутри архива
+func (h *Handler) MoveEntry(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validName(name) {
+		http.Error(w, "Error file name", http.StatusBadRequest)
+		return
+	}
+
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.From == "" || !validEntryName(req.To) {
+		http.Error(w, "Error entry name", http.StatusBadRequest)
+		return
+	}
+
+	filename := zipName(name)
+	if _, err := os.Stat(h.storage.Path(filename)); err != nil {
+		http.Error(w, "Error not such file", http.StatusNotFound)
+		return
+	}
+
+	unlock := h.storage.Lock(filename)
+	defer unlock()
+
+	password, ok := h.archivePassword(filename, r)
+	if !ok {
+		http.Error(w, "Error password", http.StatusForbidden)
+		return
+	}
+
+	err := moveEntry(h.storage.Path(filename), req.From, req.To, h.compression, password)
+	switch {
+	case errors.Is(err, ErrEntryNotFound):
+		http.Error(w, "Error not such entry", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrEntryExists):
+		http.Error(w, "Error entry already exists", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Move entry failed: %v", err)
+		http.Error(w, "Error rewrite zip", http.StatusInternalServerError)
+		return
+	}
+	h.storage.Touch(filename)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"filename": filename,
+		"entry":    req.To,
+	})
+}
+
+// Пароль архива из заголовка: архив с паролем меняется только с ним, манифест в нём зашифрован.
+// У архива без пароля заголовок не используется
+func (h *Handler) archivePassword(filename string, r *http.Request) (string, bool) {
+	meta, err := h.storage.ReadMeta(filename)
+	if err != nil || meta.PasswordHash == "" {
+		return "", true
+	}
+	password := r.Header.Get(PasswordHeader)
+	salt, _ := hex.DecodeString(meta.PasswordSalt)
+	return password, password != "" && hashPassword(password, salt) == meta.PasswordHash
+}
@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// виды событий задачи
+const (
+	EventStarted  = "started"  // началось скачивание ссылки
+	EventProgress = "progress" // скачано ещё немного байт
+	EventDone     = "done"     // ссылка скачана
+	EventFailed   = "failed"   // ссылку скачать не удалось
+	EventArchive  = "archive"  // архив собран и записан
+	EventFinished = "finished" // задача завершилась, после этого событий не будет
+)
+
+const (
+	progressPeriod = 64 * 1024        // как часто отправляем progress, в байтах
+	subscriberBuf  = 256              // сколько событий ждут медленного клиента
+	historyTTL     = 10 * time.Minute // сколько храним историю завершённой задачи
+)
+
+// Событие задачи
+type Event struct {
+	TaskID string    `json:"task_id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+	Total  int64     `json:"total,omitempty"`
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Шина событий: скачивание публикует, клиенты /tasks/{id}/events читают.
+// Для каждой задачи хранится история без progress, чтобы подключившийся позже видел что уже было.
+// Через historyTTL после завершения задачи её история удаляется
+type Bus struct {
+	mu         sync.Mutex
+	subs       map[string]map[chan Event]struct{}
+	history    map[string][]Event
+	finished   map[string]bool
+	historyTTL time.Duration
+}
+
+// Создаём шину
+func NewBus() *Bus {
+	return &Bus{
+		subs:       make(map[string]map[chan Event]struct{}),
+		history:    make(map[string][]Event),
+		finished:   make(map[string]bool),
+		historyTTL: historyTTL,
+	}
+}
+
+// Меняем сколько храним историю завершённых задач
+func (b *Bus) SetHistoryTTL(ttl time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.historyTTL = ttl
+}
+
+// Публикуем событие, медленным подписчикам progress может не дойти
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.finished[event.TaskID] {
+		return
+	}
+	if event.Type != EventProgress {
+		b.history[event.TaskID] = append(b.history[event.TaskID], event)
+	}
+
+	for ch := range b.subs[event.TaskID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	// задача завершилась, закрываем подписки
+	if event.Type == EventFinished {
+		b.finished[event.TaskID] = true
+		for ch := range b.subs[event.TaskID] {
+			close(ch)
+		}
+		delete(b.subs, event.TaskID)
+
+		// статус задачи остаётся в очереди, а история больше не нужна
+		time.AfterFunc(b.historyTTL, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.history, event.TaskID)
+			delete(b.finished, event.TaskID)
+		})
+	}
+}
+
+// Подписываемся на события задачи. Возвращает историю, канал новых событий
+// (закрывается после finished) и функцию отписки
+func (b *Bus) Subscribe(taskID string) ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	history := append([]Event{}, b.history[taskID]...)
+	ch := make(chan Event, subscriberBuf)
+
+	if b.finished[taskID] {
+		close(ch)
+		return history, ch, func() {}
+	}
+
+	if b.subs[taskID] == nil {
+		b.subs[taskID] = make(map[chan Event]struct{})
+	}
+	b.subs[taskID][ch] = struct{}{}
+
+	return history, ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[taskID][ch]; ok {
+			delete(b.subs[taskID], ch)
+			close(ch)
+		}
+		if len(b.subs[taskID]) == 0 {
+			delete(b.subs, taskID)
+		}
+	}
+}
+
+// Читатель, который сообщает сколько байт уже прочитано
+type progressReader struct {
+	reader   io.Reader
+	read     int64
+	reported int64
+	report   func(read int64)
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.reader.Read(buf)
+	p.read += int64(n)
+	if p.read-p.reported >= progressPeriod {
+		p.reported = p.read
+		p.report(p.read)
+	}
+	return n, err
+}
+
+// Поток событий задачи в формате Server-Sent Events
+func (h *Handler) TaskEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := h.queue.Get(id); !ok {
+		http.Error(w, "Error not such task", http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	history, events, cancel := h.events.Subscribe(id)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range history {
+		writeEvent(w, event)
+	}
+
+	// задача могла завершиться до перезапуска сервера, тогда событий уже не будет
+	if task, _ := h.queue.Get(id); task.Finished() {
+		if len(history) == 0 || history[len(history)-1].Type != EventFinished {
+			writeEvent(w, Event{TaskID: id, Type: EventFinished, Time: task.UpdatedAt, Status: task.Status, Error: task.Error})
+		}
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event Event) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
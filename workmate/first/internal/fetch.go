@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Скачивает файлы по ссылкам одной схемы (http, ftp, s3...)
+type Fetcher interface {
+	// Открываем файл по ссылке, отмена ctx обрывает скачивание.
+	// Ошибку источника (например код ответа) лучше вернуть как *DownloadError
+	Fetch(ctx context.Context, u *url.URL, opts FetchOptions) (*FetchResponse, error)
+}
+
+// Заголовки и учётные данные для запроса к источнику
+type FetchOptions struct {
+	Headers     map[string]string
+	Credentials *Credentials
+}
+
+// Открытый файл по ссылке
+type FetchResponse struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64  // -1 если размер неизвестен
+	Name        string // имя файла из ссылки, пустое - придумываем сами
+	FinalURL    string // куда привели редиректы, пустая - редиректов не было
+}
+
+// Правила перехода по редиректам
+type RedirectPolicy struct {
+	MaxHops        int  `json:"max_hops"`        // сколько редиректов можно пройти, 0 - ни одного
+	AllowDowngrade bool `json:"allow_downgrade"` // можно ли уйти с https на http
+	SameHost       bool `json:"same_host"`       // только в пределах хоста из ссылки
+}
+
+// Схемы, которые сервер скачивает сам
+func defaultFetchers(web httpFetcher) map[string]Fetcher {
+	return map[string]Fetcher{
+		"http":  web,
+		"https": web,
+		"data":  dataFetcher{},
+		"ftp":   ftpFetcher{},
+	}
+}
+
+// Скачивание по http и https
+type httpFetcher struct {
+	client *http.Client
+}
+
+// Клиент поверх общего транспорта со своей политикой редиректов
+func newHTTPFetcher(transport *http.Transport, redirects RedirectPolicy) httpFetcher {
+	return httpFetcher{client: &http.Client{
+		Timeout:       30 * time.Second,
+		Transport:     transport,
+		CheckRedirect: redirects.check,
+	}}
+}
+
+func (f httpFetcher) Fetch(ctx context.Context, u *url.URL, opts FetchOptions) (*FetchResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, &DownloadError{Class: ErrorClassInvalidURL, Err: fmt.Errorf("invalid URL")}
+	}
+	opts.apply(req)
+	resp, err := f.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Name = filepath.Base(u.String())
+	return resp, nil
+}
+
+// Выполняем запрос, ответ не 200 считаем ошибкой источника
+func (f httpFetcher) do(req *http.Request) (*FetchResponse, error) {
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var finalURL string
+	if resp.Request.URL.String() != req.URL.String() {
+		finalURL = redactURL(resp.Request.URL.String())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &DownloadError{
+			Class:      ErrorClassHTTPStatus,
+			StatusCode: resp.StatusCode,
+			Err:        fmt.Errorf("server returned: %s", resp.Status),
+		}
+	}
+
+	return &FetchResponse{
+		Body:        resp.Body,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+		FinalURL:    finalURL,
+	}, nil
+}
+
+// Проверяем можно ли перейти по очередному редиректу, via - уже сделанные запросы
+func (p RedirectPolicy) check(req *http.Request, via []*http.Request) error {
+	if len(via) > p.MaxHops {
+		return &DownloadError{Class: ErrorClassRedirect, Err: fmt.Errorf("too many redirects: more than %d", p.MaxHops)}
+	}
+	target := redactURL(req.URL.String())
+	if !p.AllowDowngrade && req.URL.Scheme != "https" {
+		for _, prev := range via {
+			if prev.URL.Scheme == "https" {
+				return &DownloadError{Class: ErrorClassRedirect, Err: fmt.Errorf("redirect from https to %s refused", target)}
+			}
+		}
+	}
+	if strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		return nil
+	}
+	if p.SameHost {
+		return &DownloadError{Class: ErrorClassRedirect, Err: fmt.Errorf("redirect to other host %s refused", target)}
+	}
+	// заголовки из запроса и профиля предназначены только хосту из ссылки,
+	// Go сам убирает лишь Authorization и Cookie
+	for name := range req.Header {
+		delete(req.Header, name)
+	}
+	return nil
+}
+
+// Добавляем к http запросу заголовки и авторизацию
+func (opts FetchOptions) apply(req *http.Request) {
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
+	if opts.Credentials == nil {
+		return
+	}
+	if opts.Credentials.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Credentials.Token)
+	} else if opts.Credentials.Username != "" {
+		req.SetBasicAuth(opts.Credentials.Username, opts.Credentials.Password)
+	}
+}
+
+// Файл прямо в ссылке: data:[<тип>][;base64],<данные>
+type dataFetcher struct{}
+
+func (dataFetcher) Fetch(ctx context.Context, u *url.URL, opts FetchOptions) (*FetchResponse, error) {
+	raw := u.Opaque
+	if u.RawQuery != "" {
+		raw += "?" + u.RawQuery
+	}
+	header, data, ok := strings.Cut(raw, ",")
+	if !ok {
+		return nil, &DownloadError{Class: ErrorClassInvalidURL, Err: fmt.Errorf("invalid data URI")}
+	}
+
+	contentType, isBase64 := strings.CutSuffix(header, ";base64")
+	if contentType == "" || strings.HasPrefix(contentType, ";") {
+		contentType = "text/plain" + contentType
+	}
+
+	var content []byte
+	if isBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, &DownloadError{Class: ErrorClassInvalidURL, Err: fmt.Errorf("invalid data URI: %v", err)}
+		}
+		content = decoded
+	} else {
+		unescaped, err := url.PathUnescape(data)
+		if err != nil {
+			return nil, &DownloadError{Class: ErrorClassInvalidURL, Err: fmt.Errorf("invalid data URI: %v", err)}
+		}
+		content = []byte(unescaped)
+	}
+
+	return &FetchResponse{
+		Body:        io.NopCloser(bytes.NewReader(content)),
+		ContentType: contentType,
+		Size:        int64(len(content)),
+	}, nil
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"strings"
+	"time"
+)
+
+// форматы архивов
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// Пишет файлы в архив какого-то формата
+type ArchiveWriter interface {
+	// Добавляем файл, size - его размер (tar пишет размер до содержимого)
+	Add(name string, size int64, modified time.Time, r io.Reader) error
+	// Дописываем конец архива, сам w не закрывается
+	Close() error
+}
+
+// Формат архива: расширение, Content-Type и как создать писателя
+type ArchiveFormat struct {
+	Name        string
+	Ext         string
+	ContentType string
+	// dir - папка для временных файлов, рядом с самим архивом
+	NewWriter func(w io.Writer, compression Compression, password string, dir string) ArchiveWriter
+}
+
+// Пароль поддерживает только zip, tar форматы его не получают.
+// tar.zst не поддерживаем: в стандартной библиотеке нет zstd, а внешних зависимостей у сервиса нет
+var formats = map[string]ArchiveFormat{
+	FormatZip: {
+		Name:        FormatZip,
+		Ext:         ".zip",
+		ContentType: "application/zip",
+		NewWriter: func(w io.Writer, compression Compression, password string, dir string) ArchiveWriter {
+			return newZipArchive(zip.NewWriter(w), compression, password, dir)
+		},
+	},
+	FormatTar: {
+		Name:        FormatTar,
+		Ext:         ".tar",
+		ContentType: "application/x-tar",
+		NewWriter: func(w io.Writer, compression Compression, password string, dir string) ArchiveWriter {
+			return &tarArchive{tar: tar.NewWriter(w)}
+		},
+	},
+	FormatTarGz: {
+		Name:        FormatTarGz,
+		Ext:         ".tar.gz",
+		ContentType: "application/gzip",
+		NewWriter: func(w io.Writer, compression Compression, password string, dir string) ArchiveWriter {
+			level := compression.level()
+			if compression.Mode == CompressionStore {
+				level = gzip.NoCompression
+			}
+			gz, _ := gzip.NewWriterLevel(w, level)
+			return &tarArchive{tar: tar.NewWriter(gz), gz: gz}
+		},
+	},
+}
+
+// Формат из запроса, по умолчанию zip
+func parseFormat(name string) (ArchiveFormat, bool) {
+	if name == "" {
+		name = FormatZip
+	}
+	format, ok := formats[name]
+	return format, ok
+}
+
+// Добавляем к имени расширение формата, если его нет
+func (f ArchiveFormat) FileName(name string) string {
+	if !strings.HasSuffix(name, f.Ext) {
+		name += f.Ext
+	}
+	return name
+}
+
+// zip архив, метод сжатия каждого файла выбирает политика,
+// если задан пароль - файлы шифруются AES-256
+type zipArchive struct {
+	zip         *zip.Writer
+	compression Compression
+	password    string
+	dir         string // где сжимать файлы перед шифрованием
+}
+
+func newZipArchive(zipWriter *zip.Writer, compression Compression, password string, dir string) *zipArchive {
+	compression.register(zipWriter)
+	return &zipArchive{zip: zipWriter, compression: compression, password: password, dir: dir}
+}
+
+func (a *zipArchive) Add(name string, size int64, modified time.Time, r io.Reader) error {
+	if a.password != "" {
+		return writeEncrypted(a.zip, name, a.compression.method(name), a.compression.level(), modified, r, size, a.password, a.dir)
+	}
+
+	header := &zip.FileHeader{Name: name, Method: a.compression.method(name), Modified: modified}
+	writer, err := a.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, r)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zip.Close()
+}
+
+// tar архив, для tar.gz поверх gzip
+type tarArchive struct {
+	tar *tar.Writer
+	gz  *gzip.Writer
+}
+
+func (a *tarArchive) Add(name string, size int64, modified time.Time, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modified,
+	}
+	if err := a.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tar, r)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tar.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
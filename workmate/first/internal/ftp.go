@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Скачивание по ftp:// в пассивном режиме, без логина заходим как anonymous.
+// Логин берётся из ссылки или учётных данных, заголовки у ftp нет
+type ftpFetcher struct{}
+
+func (ftpFetcher) Fetch(ctx context.Context, u *url.URL, opts FetchOptions) (*FetchResponse, error) {
+	user, password := "anonymous", "anonymous"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if opts.Credentials != nil && opts.Credentials.Username != "" {
+		user, password = opts.Credentials.Username, opts.Credentials.Password
+	}
+	// всё это уходит в команды сервера, перевод строки в них добавил бы свою команду
+	for _, arg := range []string{u.Path, user, password} {
+		if strings.ContainsAny(arg, "\r\n\x00") {
+			return nil, &DownloadError{Class: ErrorClassInvalidURL, Err: fmt.Errorf("invalid ftp URL: control characters")}
+		}
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	control := textproto.NewConn(conn)
+
+	// отмена задачи закрывает соединения, чтение сразу обрывается
+	stop := context.AfterFunc(ctx, func() { control.Close() })
+	fail := func(err error) (*FetchResponse, error) {
+		stop()
+		control.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if _, _, err := control.ReadResponse(220); err != nil {
+		return fail(ftpError(err))
+	}
+
+	code, _, err := ftpCmd(control, 0, "USER %s", user)
+	if err != nil {
+		return fail(ftpError(err))
+	}
+	if code == 331 {
+		if _, _, err := ftpCmd(control, 230, "PASS %s", password); err != nil {
+			return fail(ftpError(err))
+		}
+	} else if code != 230 {
+		return fail(&DownloadError{Class: ErrorClassNetwork, Err: fmt.Errorf("ftp login failed: %d", code)})
+	}
+
+	if _, _, err := ftpCmd(control, 200, "TYPE I"); err != nil {
+		return fail(ftpError(err))
+	}
+
+	// размер знать не обязательно, не все серверы умеют SIZE
+	size := int64(-1)
+	if _, msg, err := ftpCmd(control, 213, "SIZE %s", u.Path); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil {
+			size = n
+		}
+	}
+
+	addr, err := ftpPassive(control, conn.RemoteAddr())
+	if err != nil {
+		return fail(ftpError(err))
+	}
+	data, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fail(err)
+	}
+	stopData := context.AfterFunc(ctx, func() { data.Close() })
+
+	if _, _, err := ftpCmd(control, 0, "RETR %s", u.Path); err != nil {
+		stopData()
+		data.Close()
+		return fail(ftpError(err))
+	}
+
+	return &FetchResponse{
+		Body:        &ftpBody{data: data, control: control, stop: func() { stop(); stopData() }},
+		ContentType: mime.TypeByExtension(path.Ext(u.Path)),
+		Size:        size,
+		Name:        path.Base(u.Path),
+	}, nil
+}
+
+// Отправляем команду и читаем ответ, expect 0 - подходит любой успешный ответ
+func ftpCmd(control *textproto.Conn, expect int, format string, args ...any) (int, string, error) {
+	if _, err := control.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	code, msg, err := control.ReadResponse(expect)
+	if err == nil && expect == 0 && code >= 400 {
+		err = &textproto.Error{Code: code, Msg: msg}
+	}
+	return code, msg, err
+}
+
+// Адрес для соединения с данными: сначала EPSV, потом PASV
+func ftpPassive(control *textproto.Conn, remote net.Addr) (string, error) {
+	host, _, _ := net.SplitHostPort(remote.String())
+
+	// ответ вида "229 Entering Extended Passive Mode (|||port|)"
+	if _, msg, err := ftpCmd(control, 229, "EPSV"); err == nil {
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start >= 0 && end > start+4 {
+			return net.JoinHostPort(host, msg[start+4:end]), nil
+		}
+	}
+
+	// ответ вида "227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)"
+	_, msg, err := ftpCmd(control, 227, "PASV")
+	if err != nil {
+		return "", err
+	}
+	start, end := strings.Index(msg, "("), strings.Index(msg, ")")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("bad PASV reply: %s", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("bad PASV reply: %s", msg)
+	}
+	p1, err1 := strconv.Atoi(parts[4])
+	p2, err2 := strconv.Atoi(parts[5])
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("bad PASV reply: %s", msg)
+	}
+	// адрес из ответа не берём: за NAT сервер называет внутренний
+	return net.JoinHostPort(host, strconv.Itoa(p1<<8|p2)), nil
+}
+
+// Ответ ftp сервера с ошибкой: 550 и подобные - файла нет или нет доступа
+func ftpError(err error) error {
+	if protoErr, ok := err.(*textproto.Error); ok {
+		return &DownloadError{Class: ErrorClassFTPStatus, Err: fmt.Errorf("ftp server returned: %d %s", protoErr.Code, protoErr.Msg)}
+	}
+	return err
+}
+
+// Содержимое файла, после чтения проверяем что сервер подтвердил передачу
+type ftpBody struct {
+	data    net.Conn
+	control *textproto.Conn
+	stop    func()
+	done    bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	n, err := b.data.Read(p)
+	if err == io.EOF && !b.done {
+		b.done = true
+		// 226 - передача завершена, иначе файл пришёл не целиком
+		if _, _, respErr := b.control.ReadResponse(226); respErr != nil {
+			return n, ftpError(respErr)
+		}
+	}
+	return n, err
+}
+
+func (b *ftpBody) Close() error {
+	b.stop()
+	b.data.Close()
+	b.control.Cmd("QUIT")
+	return b.control.Close()
+}
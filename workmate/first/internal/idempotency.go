@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// заголовки ключа идемпотентности и признака повторного ответа
+const (
+	IdempotencyHeader = "Idempotency-Key"
+	ReplayedHeader    = "Idempotent-Replayed"
+)
+
+// сохранённый ответ на запрос с ключом
+type idempotentResponse struct {
+	hash    [32]byte // хэш метода, пути и тела запроса
+	created time.Time
+	done    chan struct{} // закрывается когда первый запрос обработан
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Запоминает ответы на запросы с заголовком Idempotency-Key: повтор того же запроса
+// получает сохранённый ответ, а не выполняется второй раз
+type Idempotency struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+// Создаём хранилище ответов, ответ помним window
+func NewIdempotency(window time.Duration) *Idempotency {
+	return &Idempotency{window: window, responses: make(map[string]*idempotentResponse)}
+}
+
+// Оборачиваем изменяющую ручку. Запросы без ключа проходят как есть,
+// ключ с другим запросом отклоняется 422. Ответы 5xx, 401 и 403 не запоминаются - их можно повторить,
+// в том числе с верным паролем: заголовки в хэш запроса не входят
+func (i *Idempotency) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+
+		for {
+			saved, first := i.start(key, hash)
+			if first {
+				i.record(key, saved, w, r, next)
+				return
+			}
+
+			if saved.hash != hash {
+				http.Error(w, "Error idempotency key reused with different request", http.StatusUnprocessableEntity)
+				return
+			}
+
+			// такой же запрос ещё выполняется, ждём его ответ
+			select {
+			case <-saved.done:
+			case <-r.Context().Done():
+				return
+			}
+
+			// первый запрос закончился ошибкой, которую можно повторить, выполняем заново
+			if saved.status == 0 {
+				continue
+			}
+
+			for name, values := range saved.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(saved.status)
+			w.Write(saved.body)
+			return
+		}
+	}
+}
+
+// Находим ответ по ключу или заводим новый, если ключа нет. Заодно забываем старые ответы
+func (i *Idempotency) start(key string, hash [32]byte) (*idempotentResponse, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	for k, saved := range i.responses {
+		if saved.status != 0 && now.Sub(saved.created) > i.window {
+			delete(i.responses, k)
+		}
+	}
+
+	if saved, ok := i.responses[key]; ok {
+		return saved, false
+	}
+
+	saved := &idempotentResponse{hash: hash, created: now, done: make(chan struct{})}
+	i.responses[key] = saved
+	return saved, true
+}
+
+// Выполняем запрос и запоминаем ответ
+func (i *Idempotency) record(key string, saved *idempotentResponse, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		i.mu.Lock()
+		if retryable(recorder.status) {
+			delete(i.responses, key)
+		} else {
+			saved.status = recorder.status
+			saved.header = w.Header().Clone()
+			saved.body = recorder.body.Bytes()
+			saved.created = time.Now()
+		}
+		i.mu.Unlock()
+		close(saved.done)
+	}()
+
+	next(recorder, r)
+}
+
+// Ответ, который не запоминаем: ошибка сервера или неверные учётные данные
+func retryable(status int) bool {
+	return status >= 500 || status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// Пишет ответ клиенту и запоминает его копию
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	rr.body.Write(data)
+	return rr.ResponseWriter.Write(data)
+}
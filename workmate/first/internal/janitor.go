@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// что уборщик сделал (или сделал бы) с архивом
+const (
+	SweepKeep   = "keep"
+	SweepPinned = "pinned"
+	SweepDelete = "delete"
+)
+
+// строка отчёта уборщика
+type SweepItem struct {
+	FileName  string    `json:"filename"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Action    string    `json:"action"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// отчёт об одном проходе уборщика
+type SweepReport struct {
+	DryRun bool        `json:"dry_run"`
+	Items  []SweepItem `json:"items"`
+}
+
+// Удаляет устаревшие архивы. Трогает только архивы с метаданными,
+// остальные zip файлы в папке к сервису не относятся
+type Janitor struct {
+	storage    *Storage
+	interval   time.Duration
+	adminToken string
+}
+
+// Создаём уборщика
+func NewJanitor(storage *Storage, interval time.Duration, adminToken string) *Janitor {
+	return &Janitor{storage: storage, interval: interval, adminToken: adminToken}
+}
+
+// Периодически чистим хранилище
+func (j *Janitor) Run() {
+	for {
+		report := j.Sweep(false)
+		for _, item := range report.Items {
+			if item.Action == SweepDelete {
+				log.Printf("File deleted: %s (expired %v)", item.FileName, item.ExpiresAt)
+			}
+		}
+		time.Sleep(j.interval)
+	}
+}
+
+// Один проход уборщика, при dryRun только составляем отчёт
+func (j *Janitor) Sweep(dryRun bool) SweepReport {
+	report := SweepReport{DryRun: dryRun, Items: []SweepItem{}}
+
+	metas, err := j.storage.List()
+	if err != nil {
+		log.Printf("Error read dir: %v", err)
+		return report
+	}
+
+	now := time.Now()
+	for _, meta := range metas {
+		item := SweepItem{
+			FileName:  meta.FileName,
+			ExpiresAt: j.storage.ExpiresAt(meta),
+			Action:    SweepKeep,
+		}
+
+		switch {
+		case meta.Pinned:
+			item.Action = SweepPinned
+		case now.After(item.ExpiresAt):
+			item.Action = SweepDelete
+			if !dryRun {
+				j.remove(&item, now)
+			}
+		}
+
+		report.Items = append(report.Items, item)
+	}
+	return report
+}
+
+// удаляем архив, если с ним сейчас работает запрос - пропускаем до следующего прохода
+func (j *Janitor) remove(item *SweepItem, now time.Time) {
+	unlock, ok := j.storage.TryLock(item.FileName)
+	if !ok {
+		item.Action = SweepKeep
+		item.Error = "archive is busy"
+		return
+	}
+	defer unlock()
+
+	// пока составляли список, архив могли закрепить или дописать - проверяем заново под блокировкой
+	meta, err := j.storage.ReadMeta(item.FileName)
+	if err != nil {
+		item.Action = SweepKeep
+		item.Error = err.Error()
+		return
+	}
+	item.ExpiresAt = j.storage.ExpiresAt(meta)
+	switch {
+	case meta.Pinned:
+		item.Action = SweepPinned
+		return
+	case !now.After(item.ExpiresAt):
+		item.Action = SweepKeep
+		return
+	}
+
+	if err := j.storage.Remove(item.FileName); err != nil {
+		item.Error = err.Error()
+	}
+}
+
+// GET - отчёт без удаления, POST - запуск уборки.
+// Без admin_token в настройках уборку по запросу запустить нельзя
+func (j *Janitor) HandleSweep(w http.ResponseWriter, r *http.Request) {
+	if j.adminToken == "" {
+		http.Error(w, "Forbidden: admin_token is not set", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+j.adminToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var report SweepReport
+	switch r.Method {
+	case http.MethodGet:
+		report = j.Sweep(true)
+	case http.MethodPost:
+		report = j.Sweep(r.URL.Query().Get("dry_run") == "true")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// виды записей журнала
+const (
+	JournalCreated = "created" // задача создана, в записи лежит сама задача
+	JournalStatus  = "status"  // задача сменила статус
+	JournalResult  = "result"  // результат по одной ссылке
+)
+
+// Запись журнала задач
+type JournalEntry struct {
+	Time     time.Time  `json:"time"`
+	Type     string     `json:"type"`
+	TaskID   string     `json:"task_id"`
+	Task     *Task      `json:"task,omitempty"`
+	Status   string     `json:"status,omitempty"`
+	URL      string     `json:"url,omitempty"`
+	FileName string     `json:"filename,omitempty"`
+	Link     string     `json:"link,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Result   *URLResult `json:"result,omitempty"` // отчёт по ссылке
+}
+
+// Журнал задач: файл, в который дописываются json строки.
+// При запуске по нему восстанавливаются задачи
+type Journal struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Открываем журнал, если файла нет - создаём
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+// Дописываем запись и сразу сбрасываем на диск
+func (j *Journal) Append(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.write(entry)
+}
+
+// пишем запись под блокировкой журнала
+func (j *Journal) write(entry JournalEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Читаем журнал и собираем задачи в порядке создания
+func (j *Journal) Replay() ([]*Task, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tasks []*Task
+	byID := make(map[string]*Task)
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry JournalEntry
+			// последняя строка могла оборваться при падении, такие пропускаем
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr == nil {
+				tasks = applyEntry(tasks, byID, entry)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+func applyEntry(tasks []*Task, byID map[string]*Task, entry JournalEntry) []*Task {
+	if entry.Type == JournalCreated {
+		if entry.Task == nil {
+			return tasks
+		}
+		task := *entry.Task
+		byID[task.ID] = &task
+		return append(tasks, &task)
+	}
+
+	task, ok := byID[entry.TaskID]
+	if !ok {
+		return tasks
+	}
+
+	switch entry.Type {
+	case JournalStatus:
+		task.Status = entry.Status
+		task.Error = entry.Error
+		task.UpdatedAt = entry.Time
+		if entry.Link != "" {
+			task.Link = entry.Link
+		}
+	case JournalResult:
+		if entry.Result != nil {
+			task.Results = append(task.Results, *entry.Result)
+		}
+		if entry.Error != "" {
+			e := ErrorResponse{URL: entry.URL, Error: entry.Error}
+			if entry.Result != nil {
+				e.Class, e.HTTPStatus = entry.Result.ErrorClass, entry.Result.HTTPStatus
+			}
+			task.Errors = append(task.Errors, e)
+		}
+	}
+	return tasks
+}
+
+// Переписываем журнал так, чтобы в нём осталось только текущее состояние задач
+func (j *Journal) Compact(tasks []*Task) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact(tasks)
+}
+
+// переписываем журнал под его блокировкой
+func (j *Journal) compact(tasks []*Task) error {
+	err := writeAtomic(j.path, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, task := range tasks {
+			if err := encoder.Encode(JournalEntry{Time: task.CreatedAt, Type: JournalCreated, TaskID: task.ID, Task: task}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// старый файл подменён, открываем новый
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+// Закрываем журнал
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// пишем в журнал если он есть, ошибки только логируем - задача важнее журнала
+func (q *Queue) journal(entry JournalEntry) {
+	if q.log == nil {
+		return
+	}
+	if err := q.log.Append(entry); err != nil {
+		log.Printf("Error write journal: %v", err)
+	}
+}
+
+// fn меняет очередь и возвращает запись для журнала (nil - писать нечего).
+// fn выполняется под блокировкой журнала, поэтому записи о том, что случилось после fn,
+// попадут в журнал позже. Блокировку очереди fn берёт сам, на диск пишем уже без неё
+func (q *Queue) journalAfter(fn func() *JournalEntry) {
+	if q.log == nil {
+		fn()
+		return
+	}
+
+	q.log.mu.Lock()
+	defer q.log.mu.Unlock()
+
+	entry := fn()
+	if entry == nil {
+		return
+	}
+	if err := q.log.write(*entry); err != nil {
+		log.Printf("Error write journal: %v", err)
+	}
+}
@@ -1,38 +1,311 @@
 package internal
 
-import "errors"
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
 
-var ErrLimit = errors.New("err limit")
+// приоритеты запросов
+const (
+	PriorityInteractive = "interactive" // пользователь ждёт несколько файлов
+	PriorityBatch       = "batch"       // большие задачи, могут подождать
+)
 
-// ограничение одновременых запросов
+// Настройки скачивания
+type DownloadsConfig struct {
+	Slots    int      `json:"slots"`    // сколько ссылок скачивается одновременно
+	Reserved int      `json:"reserved"` // сколько из них занимает только interactive
+	Aging    Duration `json:"aging"`    // через сколько ожидания batch получает права interactive
+
+	MaxBytes     int64    `json:"max_bytes"`     // максимальный размер файла, 0 - без ограничения
+	AllowedTypes []string `json:"allowed_types"` // разрешённые Content-Type, пустой - любые
+
+	PerHost   int                  `json:"per_host"`   // сколько ссылок одного хоста скачивается одновременно, 0 - без ограничения
+	HostDelay Duration             `json:"host_delay"` // пауза между началом запросов к одному хосту
+	Hosts     map[string]HostLimit `json:"hosts"`      // свои ограничения для доменов
+
+	// учётные данные для источников по имени профиля, в ответы и журнал не попадают
+	Profiles map[string]CredentialProfile `json:"profiles"`
+
+	Redirects RedirectPolicy  `json:"redirects"`
+	Transport TransportConfig `json:"transport"` // прокси и TLS
+}
+
+// Ограничения для домена, действуют и на его поддомены. Нулевые поля берутся из общих
+type HostLimit struct {
+	PerHost int      `json:"per_host"`
+	Delay   Duration `json:"delay"`
+}
+
+// ограничение одновременых запросов с приоритетами: часть слотов
+// оставлена для interactive, batch который долго ждёт повышается до interactive.
+// Для каждого хоста можно ограничить число запросов и паузу между ними,
+// свободный слот достаётся хосту, у которого сейчас меньше всего запросов
 type RateLimiter struct {
-	lim chan struct{}
+	mu       sync.Mutex
+	max      int
+	reserved int
+	aging    time.Duration
+	busy     int
+	waiters  []*waiter
+
+	perHost   int
+	hostDelay time.Duration
+	overrides map[string]HostLimit
+	hosts     map[string]*hostState
+	timer     *time.Timer // будит dispatch когда у хоста пройдёт пауза
+}
+
+// запрос слота, который ждёт своей очереди
+type waiter struct {
+	priority string
+	host     string
+	since    time.Time
+	ready    chan struct{}
+}
+
+// сколько запросов к хосту идёт сейчас и когда начался последний
+type hostState struct {
+	busy int
+	last time.Time
 }
 
 // Создание лимитера
 func NewRateLimiter(maxConcurrent int) *RateLimiter {
-	return &RateLimiter{
-		lim: make(chan struct{}, maxConcurrent),
+	return &RateLimiter{max: maxConcurrent, hosts: make(map[string]*hostState)}
+}
+
+// Оставляем reserved слотов для interactive и задаём через сколько batch повышается
+func (rl *RateLimiter) SetPriorities(reserved int, aging time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if reserved >= rl.max {
+		reserved = rl.max - 1
 	}
+	rl.reserved = reserved
+	rl.aging = aging
 }
 
-// Занимаем слот если есть свободный
-func (rl *RateLimiter) TryAcquire() error {
+// Ограничиваем запросы к одному хосту: perHost одновременно и delay между началом запросов,
+// overrides - свои значения для доменов
+func (rl *RateLimiter) SetHosts(perHost int, delay time.Duration, overrides map[string]HostLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.perHost = perHost
+	rl.hostDelay = delay
+	rl.overrides = overrides
+}
+
+// Занимаем слот с приоритетом, ждём пока не отменят контекст
+func (rl *RateLimiter) AcquirePriority(ctx context.Context, priority string) error {
+	return rl.AcquireHost(ctx, priority, "")
+}
+
+// Занимаем слот для запроса к хосту, пустой host - без ограничений по хосту.
+// Освобождать слот нужно через ReleaseHost с тем же хостом
+func (rl *RateLimiter) AcquireHost(ctx context.Context, priority string, host string) error {
+	w := &waiter{priority: priority, host: host, since: time.Now(), ready: make(chan struct{})}
+
+	rl.mu.Lock()
+	rl.waiters = append(rl.waiters, w)
+	rl.dispatch()
+	aging := rl.aging
+	rl.mu.Unlock()
+
+	// batch может ждать при свободных зарезервированных слотах,
+	// тогда после повышения его никто не разбудит - будим сами
+	if priority == PriorityBatch && aging > 0 {
+		timer := time.AfterFunc(aging, func() {
+			rl.mu.Lock()
+			defer rl.mu.Unlock()
+			rl.dispatch()
+		})
+		defer timer.Stop()
+	}
+
 	select {
-	case rl.lim <- struct{}{}:
+	case <-w.ready:
 		return nil
-	default:
-		return ErrLimit
+	case <-ctx.Done():
 	}
-}
 
-// Занимаем слот
-func (rl *RateLimiter) Acquire() {
-	rl.lim <- struct{}{}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 
+	for i, other := range rl.waiters {
+		if other == w {
+			rl.waiters = append(rl.waiters[:i], rl.waiters[i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// слот успели выдать одновременно с отменой, отдаём его следующему
+	rl.release(host)
+	return ctx.Err()
 }
 
 // освобождаем слот
 func (rl *RateLimiter) Release() {
-	<-rl.lim
+	rl.ReleaseHost("")
+}
+
+// освобождаем слот запроса к хосту
+func (rl *RateLimiter) ReleaseHost(host string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.release(host)
+}
+
+// Вызывается под блокировкой
+func (rl *RateLimiter) release(host string) {
+	rl.busy--
+	if state, ok := rl.hosts[host]; ok {
+		state.busy--
+	}
+	rl.dispatch()
+}
+
+// Раздаём свободные слоты ждущим: сначала interactive и повышенные batch,
+// внутри - хосты с меньшим числом запросов, потом кто дольше ждёт.
+// Ждущие, чей хост занят или ещё на паузе, пропускаются. Вызывается под блокировкой
+func (rl *RateLimiter) dispatch() {
+	now := time.Now()
+	var wake time.Duration // через сколько у какого-то хоста кончится пауза
+
+	// хост без запросов помним только пока действует пауза
+	for host, state := range rl.hosts {
+		if state.busy == 0 && now.Sub(state.last) >= rl.hostLimit(host).delay() {
+			delete(rl.hosts, host)
+		}
+	}
+
+	for len(rl.waiters) > 0 {
+		best := -1
+		for i, w := range rl.waiters {
+			ok, wait := rl.hostReady(w.host, now)
+			if !ok {
+				if wait > 0 && (wake == 0 || wait < wake) {
+					wake = wait
+				}
+				continue
+			}
+			if best < 0 || rl.before(w, rl.waiters[best], now) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		w := rl.waiters[best]
+		limit := rl.max
+		if rl.rank(w, now) > 0 {
+			limit -= rl.reserved
+		}
+		if rl.busy >= limit {
+			break
+		}
+
+		rl.busy++
+		if w.host != "" {
+			state := rl.hosts[w.host]
+			if state == nil {
+				state = &hostState{}
+				rl.hosts[w.host] = state
+			}
+			state.busy++
+			state.last = now
+		}
+		rl.waiters = append(rl.waiters[:best], rl.waiters[best+1:]...)
+		close(w.ready)
+	}
+
+	if wake > 0 {
+		if rl.timer != nil {
+			rl.timer.Stop()
+		}
+		rl.timer = time.AfterFunc(wake, func() {
+			rl.mu.Lock()
+			defer rl.mu.Unlock()
+			rl.dispatch()
+		})
+	}
+}
+
+// Кто из ждущих получит слот раньше
+func (rl *RateLimiter) before(a, b *waiter, now time.Time) bool {
+	if rankA, rankB := rl.rank(a, now), rl.rank(b, now); rankA != rankB {
+		return rankA < rankB
+	}
+	if busyA, busyB := rl.hostBusy(a.host), rl.hostBusy(b.host); busyA != busyB {
+		return busyA < busyB
+	}
+	return a.since.Before(b.since)
+}
+
+// 0 - interactive или batch, который ждёт дольше aging, 1 - обычный batch
+func (rl *RateLimiter) rank(w *waiter, now time.Time) int {
+	if w.priority == PriorityBatch && (rl.aging <= 0 || now.Sub(w.since) < rl.aging) {
+		return 1
+	}
+	return 0
+}
+
+func (rl *RateLimiter) hostBusy(host string) int {
+	if state, ok := rl.hosts[host]; ok {
+		return state.busy
+	}
+	return 0
+}
+
+// Можно ли начать запрос к хосту, если нельзя из-за паузы - через сколько станет можно
+func (rl *RateLimiter) hostReady(host string, now time.Time) (bool, time.Duration) {
+	state, ok := rl.hosts[host]
+	if host == "" || !ok {
+		return true, 0
+	}
+	limit := rl.hostLimit(host)
+	if limit.PerHost > 0 && state.busy >= limit.PerHost {
+		return false, 0
+	}
+	if wait := limit.delay() - now.Sub(state.last); wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+// Ограничения хоста: самый точный домен из overrides, остальное из общих
+func (rl *RateLimiter) hostLimit(host string) HostLimit {
+	limit := HostLimit{PerHost: rl.perHost, Delay: Duration(rl.hostDelay)}
+
+	matched := ""
+	for domain, override := range rl.overrides {
+		domain = strings.ToLower(domain)
+		if !matchDomain(host, domain) {
+			continue
+		}
+		if len(domain) <= len(matched) {
+			continue
+		}
+		matched = domain
+		if override.PerHost > 0 {
+			limit.PerHost = override.PerHost
+		} else {
+			limit.PerHost = rl.perHost
+		}
+		if override.Delay > 0 {
+			limit.Delay = override.Delay
+		} else {
+			limit.Delay = Duration(rl.hostDelay)
+		}
+	}
+	return limit
+}
+
+func (l HostLimit) delay() time.Duration {
+	return time.Duration(l.Delay)
 }
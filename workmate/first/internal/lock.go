@@ -0,0 +1,57 @@
+package internal
+
+import "sync"
+
+// блокировка одного архива, refs - сколько запросов её ждут или держат
+type archiveLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Блокируем архив, чтобы запросы к одному архиву шли по очереди.
+// Возвращает функцию для снятия блокировки
+func (s *Storage) Lock(filename string) func() {
+	lock := s.acquireLock(filename)
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		s.releaseLock(filename, lock)
+	}
+}
+
+// Блокируем архив если он свободен, иначе ok = false
+func (s *Storage) TryLock(filename string) (func(), bool) {
+	lock := s.acquireLock(filename)
+	if !lock.mu.TryLock() {
+		s.releaseLock(filename, lock)
+		return nil, false
+	}
+	return func() {
+		lock.mu.Unlock()
+		s.releaseLock(filename, lock)
+	}, true
+}
+
+func (s *Storage) acquireLock(filename string) *archiveLock {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	lock, ok := s.locks[filename]
+	if !ok {
+		lock = &archiveLock{}
+		s.locks[filename] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// когда блокировка никому не нужна, убираем её из map
+func (s *Storage) releaseLock(filename string, lock *archiveLock) {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(s.locks, filename)
+	}
+}
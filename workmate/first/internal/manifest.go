@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// служебные файлы в архиве
+const (
+	ManifestName  = "manifest.json"
+	ChecksumsName = "SHA256SUMS"
+)
+
+// Строка манифеста: что просили скачать и что из этого вышло
+type ManifestEntry struct {
+	URL         string    `json:"url"`
+	Name        string    `json:"name,omitempty"` // имя файла в архиве
+	Size        int64     `json:"size,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// Манифест архива, при каждом добавлении файлов дописывается
+type Manifest struct {
+	UpdatedAt time.Time       `json:"updated_at"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+// Дописываем в манифест результаты скачивания, errs - ошибки по ссылкам после записи в архив
+func (m *Manifest) add(results []DownloadResult, errs []ErrorResponse, now time.Time) {
+	failed := make(map[string]string)
+	for _, e := range errs {
+		failed[e.URL] = e.Error
+	}
+
+	for _, result := range results {
+		entry := ManifestEntry{URL: result.URL, AddedAt: now}
+		if msg, ok := failed[result.URL]; ok {
+			entry.Error = msg
+		} else {
+			entry.Name = result.Filename
+			entry.Size = result.Size
+			entry.SHA256 = result.SHA256
+			entry.ContentType = result.ContentType
+		}
+		m.Files = append(m.Files, entry)
+	}
+	m.UpdatedAt = now
+}
+
+// Файл в архиве переименовали, пустое to - удалили: строки манифеста про него меняются так же
+func (m *Manifest) rename(from, to string, now time.Time) {
+	files := m.Files[:0]
+	for _, entry := range m.Files {
+		if entry.Error == "" && entry.Name == from {
+			if to == "" {
+				continue
+			}
+			entry.Name = to
+		}
+		files = append(files, entry)
+	}
+	m.Files = files
+	m.UpdatedAt = now
+}
+
+// Пишем manifest.json и SHA256SUMS в архив
+func (m *Manifest) write(archive ArchiveWriter) error {
+	if m.Files == nil {
+		m.Files = []ManifestEntry{}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := archive.Add(ManifestName, int64(len(data)), m.UpdatedAt, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	// формат как у sha256sum: "<hash>  <имя>"
+	var sums bytes.Buffer
+	for _, entry := range m.Files {
+		if entry.Error == "" {
+			fmt.Fprintf(&sums, "%s  %s\n", entry.SHA256, entry.Name)
+		}
+	}
+	return archive.Add(ChecksumsName, int64(sums.Len()), m.UpdatedAt, &sums)
+}
+
+// Читаем манифест из архива на сервере, второе значение - есть ли он в архиве
+func readManifest(filename string, password string) (Manifest, bool, error) {
+	var manifest Manifest
+
+	// пустой файл считаем пустым архивом
+	if info, err := os.Stat(filename); err != nil || info.Size() == 0 {
+		return manifest, false, err
+	}
+
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return manifest, false, err
+	}
+	defer reader.Close()
+
+	// манифест пишется после файлов, поэтому берём последнюю запись с этим именем
+	var entry *zip.File
+	for _, f := range reader.File {
+		if f.Name == ManifestName {
+			entry = f
+		}
+	}
+	if entry == nil {
+		return manifest, false, nil
+	}
+
+	var rc io.ReadCloser
+	if entry.Method == methodAES {
+		rc, err = OpenEncrypted(entry, password, filepath.Dir(filename))
+	} else {
+		rc, err = entry.Open()
+	}
+	if err != nil {
+		return manifest, true, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return manifest, true, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, true, err
+}
+
+// служебный ли это файл, при обновлении манифеста старые копии убираются
+func isManifestEntry(name string) bool {
+	return name == ManifestName || name == ChecksumsName
+}
+
+// Скачанные файлы с именами служебных файлов переименовываем: manifest.json -> manifest_1.json,
+// иначе при следующем обновлении манифеста их заменят
+func renameReserved(results []DownloadResult) {
+	for i := range results {
+		if name := results[i].Filename; isManifestEntry(name) {
+			ext := filepath.Ext(name)
+			results[i].Filename = strings.TrimSuffix(name, ext) + "_1" + ext
+		}
+	}
+}
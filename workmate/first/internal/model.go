@@ -1,27 +1,76 @@
 package internal
 
+import (
+	"net/http"
+	"time"
+)
+
 // структура для входящего JSON
 type Request struct {
 	FileName string   `json:"filename"`
-	URLs     []string `json:"urls"`
+	URLs     []Source `json:"urls"`  // строки или объекты с заголовками и учётными данными
+	TTL      string   `json:"ttl"`   // время жизни архива, например "30m"
+	Owner    string   `json:"owner"` // владелец архива, для квоты
+	// куда отправить уведомление когда архив будет готов
+	CallbackURL string `json:"callback_url"`
+	Priority    string `json:"priority"` // interactive (по умолчанию) или batch
+	// сервер сам придумывает уникальное имя, "filename" остаётся именем для скачивания
+	GenerateName bool   `json:"generate_name"`
+	Format       string `json:"format"`      // zip (по умолчанию), tar или tar.gz
+	Compression  string `json:"compression"` // auto (по умолчанию), store или deflate
+	Level        int    `json:"level"`       // уровень deflate от 1 до 9
+	Password     string `json:"password"`    // пароль, файлы шифруются AES-256 (только zip)
+	Manifest     bool   `json:"manifest"`    // добавить в архив manifest.json и SHA256SUMS
+	// заголовки и учётные данные для всех ссылок, у ссылки могут быть свои
+	Headers     map[string]string `json:"headers"`
+	Credentials *Credentials      `json:"credentials"`
+}
+
+// запрос на переименование файла внутри архива
+type MoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 // структура для ответа об ошибках
 type ErrorResponse struct {
-	URL   string `json:"url"`
-	Error string `json:"error"`
+	URL        string `json:"url"`
+	Error      string `json:"error"`
+	Class      string `json:"error_class,omitempty"` // вид ошибки: dns, timeout, http_status...
+	HTTPStatus int    `json:"http_status,omitempty"`
+}
+
+// информация о файле внутри архива
+type EntryInfo struct {
+	Name           string    `json:"name"`
+	Size           uint64    `json:"size"`
+	CompressedSize uint64    `json:"compressed_size"`
+	CRC32          uint32    `json:"crc32"`
+	Modified       time.Time `json:"modified"`
 }
 
-// результат скачивания файла
+// результат скачивания файла, содержимое лежит во временном файле на диске
 type DownloadResult struct {
-	URL      string `json:"url"`
-	Filename string `json:"filename"`
-	Content  []byte `json:"content"`
-	Error    error  `json:"error"`
+	URL         string `json:"url"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	FinalURL    string `json:"final_url"` // куда привели редиректы
+	Path        string `json:"-"`         // временный файл с содержимым
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"` // считается во время скачивания, нужен манифесту
+	Error       error  `json:"error"`
 }
 
 // структура для удобного хранения лимитов (типа ООП) для нашего обработчика запросов
 type Handler struct {
-	limiter         *RateLimiter
+	queue           *Queue
 	limiterdownload *RateLimiter
+	storage         *Storage
+	notifier        *Notifier
+	events          *Bus
+	compression     Compression
+	downloads       DownloadsConfig
+	fetchers        map[string]Fetcher // как скачивать ссылки, по схеме
+	transport       *http.Transport    // общие соединения для http, https и s3
+	s3              *S3Config          // хранилище для ссылок s3://, nil - не настроено
 }
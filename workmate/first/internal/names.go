@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"mime"
+	"time"
+)
+
+// алфавит Crockford base32, которым записывается ULID
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID: 48 бит времени в миллисекундах и 80 случайных бит, 26 символов.
+// Сортируется по времени создания, совпасть два имени практически не могут
+func newULID() string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(id[6:])
+
+	// 128 бит пишем по 5 бит, первый символ несёт только 3 бита
+	out := make([]byte, 26)
+	var acc uint64
+	var bits uint
+	pos := len(out) - 1
+	for i := len(id) - 1; i >= 0; i-- {
+		acc |= uint64(id[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			out[pos] = crockford[acc&31]
+			pos--
+			acc >>= 5
+			bits -= 5
+		}
+	}
+	out[0] = crockford[acc&31]
+	return string(out)
+}
+
+// Имя архива, под которым его получит пользователь
+func (s *Storage) DisplayName(filename string) string {
+	if meta, err := s.ReadMeta(filename); err == nil && meta.DisplayName != "" {
+		return meta.DisplayName
+	}
+	return filename
+}
+
+// Заголовок Content-Disposition, имя экранируется
+func contentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Закрепляем архив, уборщик его не удалит
+func (h *Handler) PinArchive(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, true)
+}
+
+// Снимаем закрепление
+func (h *Handler) UnpinArchive(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, false)
+}
+
+func (h *Handler) setPinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	name := r.PathValue("name")
+	if !validName(name) {
+		http.Error(w, "Error file name", http.StatusBadRequest)
+		return
+	}
+
+	filename := zipName(name)
+	unlock := h.storage.Lock(filename)
+	defer unlock()
+
+	meta, err := h.storage.ReadMeta(filename)
+	if err != nil {
+		http.Error(w, "Error not such file", http.StatusNotFound)
+		return
+	}
+
+	meta.Pinned = pinned
+	if err := h.storage.WriteMeta(meta); err != nil {
+		http.Error(w, "Error save meta", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
@@ -0,0 +1,530 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("queue is full")
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskFinished = errors.New("task already finished")
+)
+
+// виды задач
+const (
+	TaskDownload = "download" // собрать архив и отдать в ответе /downloadandzip
+	TaskAdd      = "add"      // скачать файлы и дописать в архив на сервере
+)
+
+// статусы задач
+const (
+	TaskQueued    = "queued"
+	TaskRunning   = "running"
+	TaskDone      = "done"
+	TaskFailed    = "failed"
+	TaskCancelled = "cancelled"
+)
+
+// Задача на сборку архива
+type Task struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	FileName    string          `json:"filename"`
+	DisplayName string          `json:"display_name,omitempty"`
+	Format      string          `json:"format,omitempty"` // формат архива для TaskDownload
+	Compression string          `json:"compression,omitempty"`
+	Level       int             `json:"level,omitempty"`
+	Encrypted   bool            `json:"encrypted,omitempty"` // файлы шифруются паролем
+	Manifest    bool            `json:"manifest,omitempty"`  // в архиве ведётся manifest.json
+	Secrets     bool            `json:"secrets,omitempty"`   // у ссылок были заголовки или пароли
+	URLs        []string        `json:"urls"`
+	Errors      []ErrorResponse `json:"errors,omitempty"`
+	Results     []URLResult     `json:"results,omitempty"` // отчёт по каждой ссылке
+	Error       string          `json:"error,omitempty"`
+	Link        string          `json:"link,omitempty"` // ссылка на готовый архив
+	CallbackURL string          `json:"callback_url,omitempty"`
+	Priority    string          `json:"priority,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+
+	result   string          // временный файл с собранным архивом для TaskDownload
+	done     chan struct{}   // закрывается когда задача завершилась
+	ctx      context.Context // отменяется при отмене задачи
+	cancel   context.CancelFunc
+	password string   // в журнал и статус не попадает
+	sources  []Source // ссылки с заголовками и паролями, тоже не сохраняются
+}
+
+// Очередь задач с пулом воркеров фиксированного размера.
+// Свободный воркер берёт сначала interactive задачи, batch - когда их нет,
+// и batch задачи никогда не занимают зарезервированных под interactive воркеров
+type Queue struct {
+	workers  int
+	capacity int
+	once     sync.Once
+
+	mu        sync.Mutex
+	ready     *sync.Cond // сигналит воркерам о новых задачах в pending
+	pending   []*Task    // ждущие задачи в порядке постановки
+	idle      int        // воркеры, которые ждут задачу
+	batch     int        // воркеры, занятые batch задачами
+	reserved  int        // воркеры только для interactive
+	aging     time.Duration
+	tasks     map[string]*Task
+	log       *Journal
+	onFinish  []func(task Task)
+	onPrune   []func(id string)
+	retention time.Duration // сколько помним завершённые задачи
+}
+
+// Создаём очередь: workers задач выполняются одновременно, capacity ждут своей очереди.
+// Завершённые задачи по умолчанию помним сутки
+func NewQueue(workers int, capacity int) *Queue {
+	q := &Queue{
+		workers:   workers,
+		capacity:  capacity,
+		tasks:     make(map[string]*Task),
+		retention: 24 * time.Hour,
+	}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// Оставляем reserved воркеров для interactive и задаём через сколько ожидания
+// batch задача берётся наравне с interactive, 0 - никогда
+func (q *Queue) SetPriorities(reserved int, aging time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if reserved >= q.workers {
+		reserved = q.workers - 1
+	}
+	q.reserved = reserved
+	q.aging = aging
+}
+
+// Подключаем журнал, в него пишутся все изменения задач
+func (q *Queue) SetJournal(journal *Journal) {
+	q.log = journal
+}
+
+// Меняем сколько помним завершённые задачи
+func (q *Queue) SetRetention(retention time.Duration) {
+	q.retention = retention
+}
+
+// Подписываемся на завершение задач, вызывается в воркере после смены статуса
+func (q *Queue) OnFinish(fn func(task Task)) {
+	q.onFinish = append(q.onFinish, fn)
+}
+
+// Подписываемся на забывание задач, вызывается после Prune для каждой забытой задачи
+func (q *Queue) OnPrune(fn func(id string)) {
+	q.onPrune = append(q.onPrune, fn)
+}
+
+// Запускаем воркеров, повторный вызов ничего не делает
+func (q *Queue) Start(run func(task *Task) error) {
+	q.once.Do(func() {
+		for i := 0; i < q.workers; i++ {
+			go q.worker(run)
+		}
+	})
+}
+
+func (q *Queue) worker(run func(task *Task) error) {
+	for {
+		q.mu.Lock()
+		q.idle++
+		task, batch := q.next()
+		for task == nil {
+			q.ready.Wait()
+			task, batch = q.next()
+		}
+		q.idle--
+		if batch {
+			q.batch++
+		}
+		task.Status = TaskRunning
+		task.UpdatedAt = time.Now()
+		q.mu.Unlock()
+
+		q.journal(JournalEntry{Type: JournalStatus, TaskID: task.ID, Status: TaskRunning})
+
+		err := run(task)
+
+		// освободился воркер для batch, его может ждать другой воркер
+		if batch {
+			q.mu.Lock()
+			q.batch--
+			q.ready.Signal()
+			q.mu.Unlock()
+		}
+
+		q.finish(task, func(t *Task) {
+			switch {
+			case err == nil:
+				t.Status = TaskDone
+			case errors.Is(err, context.Canceled):
+				t.Status = TaskCancelled
+			default:
+				t.Status = TaskFailed
+				t.Error = err.Error()
+			}
+		})
+	}
+}
+
+// Достаём следующую задачу: первую interactive или batch, которая ждёт дольше aging,
+// а если таких нет - первую batch, если для неё есть незарезервированный воркер.
+// Второе значение - задача идёт как batch. Вызывается под q.mu
+func (q *Queue) next() (*Task, bool) {
+	now := time.Now()
+	i, batch := -1, false
+	for j, task := range q.pending {
+		if task.Priority != PriorityBatch || (q.aging > 0 && now.Sub(task.CreatedAt) >= q.aging) {
+			i, batch = j, false
+			break
+		}
+		if i < 0 && q.batch < q.workers-q.reserved {
+			i, batch = j, true
+		}
+	}
+	if i < 0 {
+		return nil, false
+	}
+	task := q.pending[i]
+	q.pending = append(q.pending[:i], q.pending[i+1:]...)
+	return task, batch
+}
+
+// Убираем задачу из ожидающих, вызывается под q.mu
+func (q *Queue) dequeue(task *Task) {
+	for i, t := range q.pending {
+		if t == task {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// Завершаем задачу: меняем статус, пишем в журнал и оповещаем подписчиков
+func (q *Queue) finish(task *Task, fn func(t *Task)) {
+	var finished Task
+	q.update(task, func(t *Task) {
+		fn(t)
+		finished = *t
+	})
+	q.journalResults(finished)
+	task.cancel()
+	close(task.done)
+
+	for _, fn := range q.onFinish {
+		fn(finished)
+	}
+}
+
+// Отменяем задачу. Ждущая в очереди завершается сразу, у выполняемой
+// отменяется контекст и она завершится когда воркер это заметит.
+// Возвращает канал, который закроется после завершения
+func (q *Queue) Cancel(id string) (<-chan struct{}, error) {
+	q.mu.Lock()
+	task, ok := q.tasks[id]
+	if !ok {
+		q.mu.Unlock()
+		return nil, ErrTaskNotFound
+	}
+	status := task.Status
+	if status == TaskQueued {
+		task.Status = TaskCancelled
+		q.dequeue(task)
+	}
+	q.mu.Unlock()
+
+	switch status {
+	case TaskQueued:
+		q.finish(task, func(t *Task) {})
+	case TaskRunning:
+		task.cancel()
+	default:
+		return nil, ErrTaskFinished
+	}
+	return task.done, nil
+}
+
+// Ставим задачу в очередь. Задачу сразу берёт свободный воркер,
+// если свободных нет и очередь заполнена - ErrQueueFull
+func (q *Queue) Submit(task *Task) error {
+	now := time.Now()
+	task.ID = newID()
+	task.Status = TaskQueued
+	task.CreatedAt = now
+	task.UpdatedAt = now
+	task.done = make(chan struct{})
+	task.ctx, task.cancel = context.WithCancel(context.Background())
+
+	// создание попадает в журнал раньше, чем воркер запишет смену статуса,
+	// а статус и отмена других задач не ждут записи на диск
+	var err error
+	q.journalAfter(func() *JournalEntry {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		// ждущие задачи, которые разберут свободные воркеры, место в очереди не занимают
+		if len(q.pending) >= q.capacity+q.idle {
+			task.cancel()
+			err = ErrQueueFull
+			return nil
+		}
+		q.pending = append(q.pending, task)
+		q.tasks[task.ID] = task
+		q.ready.Signal()
+		// batch может ждать при свободных зарезервированных воркерах,
+		// тогда после повышения его никто не разбудит - будим сами
+		if task.Priority == PriorityBatch && q.aging > 0 {
+			time.AfterFunc(q.aging, func() {
+				q.mu.Lock()
+				defer q.mu.Unlock()
+				q.ready.Broadcast()
+			})
+		}
+		created := *task
+		return &JournalEntry{Type: JournalCreated, TaskID: task.ID, Task: &created}
+	})
+	return err
+}
+
+// Забываем задачи, завершённые больше retention назад, и сжимаем журнал без них.
+// Возвращает сколько задач забыто
+func (q *Queue) Prune() int {
+	removed := q.prune()
+	for _, id := range removed {
+		for _, fn := range q.onPrune {
+			fn(id)
+		}
+	}
+	return len(removed)
+}
+
+// забываем старые задачи, возвращаем их id
+func (q *Queue) prune() []string {
+	var removed []string
+	prune := func() []*Task {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		cutoff := time.Now().Add(-q.retention)
+		tasks := make([]*Task, 0, len(q.tasks))
+		for id, task := range q.tasks {
+			if task.Finished() && task.UpdatedAt.Before(cutoff) {
+				// архив, который так и не забрали
+				if task.result != "" {
+					os.Remove(task.result)
+				}
+				delete(q.tasks, id)
+				removed = append(removed, id)
+				continue
+			}
+			copied := *task
+			tasks = append(tasks, &copied)
+		}
+		sort.Slice(tasks, func(i, j int) bool { return tasks[i].CreatedAt.Before(tasks[j].CreatedAt) })
+		return tasks
+	}
+
+	if q.log == nil {
+		prune()
+		return removed
+	}
+
+	// состояние снимается под блокировкой журнала: все записи после него лягут уже в новый файл
+	q.log.mu.Lock()
+	defer q.log.mu.Unlock()
+	tasks := prune()
+	if len(removed) > 0 {
+		if err := q.log.compact(tasks); err != nil {
+			log.Printf("Error compact journal: %v", err)
+		}
+	}
+	return removed
+}
+
+// Периодически забываем старые задачи
+func (q *Queue) RunPruner(interval time.Duration) {
+	for {
+		if n := q.Prune(); n > 0 {
+			log.Printf("Tasks pruned: %d", n)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Восстанавливаем задачи из журнала: завершённые просто доступны по id,
+// незавершённые ставятся в очередь заново. Журнал после этого сжимается
+func (q *Queue) Restore(tasks []*Task) {
+	var pending []*Task
+
+	q.mu.Lock()
+	for _, task := range tasks {
+		task.done = make(chan struct{})
+		task.ctx, task.cancel = context.WithCancel(context.Background())
+		q.tasks[task.ID] = task
+
+		switch {
+		case task.Finished():
+			task.cancel()
+			close(task.done)
+		case task.Kind == TaskDownload:
+			// клиент который ждал архив в ответе уже отключился
+			task.Status = TaskFailed
+			task.Error = "interrupted by restart"
+			task.cancel()
+			close(task.done)
+		case task.Encrypted:
+			// пароль не сохраняется, без него файлы не зашифровать
+			task.Status = TaskFailed
+			task.Error = "interrupted by restart, password is not kept"
+			task.cancel()
+			close(task.done)
+		case task.Secrets:
+			// заголовки и пароли ссылок тоже не сохраняются
+			task.Status = TaskFailed
+			task.Error = "interrupted by restart, credentials are not kept"
+			task.cancel()
+			close(task.done)
+		default:
+			task.Status = TaskQueued
+			pending = append(pending, task)
+		}
+	}
+	q.mu.Unlock()
+
+	if q.log != nil {
+		if err := q.log.Compact(tasks); err != nil {
+			log.Printf("Error compact journal: %v", err)
+		}
+	}
+
+	// восстановленные задачи ставятся даже сверх capacity, новые подождут пока очередь разойдётся
+	q.mu.Lock()
+	q.pending = append(q.pending, pending...)
+	q.ready.Broadcast()
+	q.mu.Unlock()
+}
+
+// Сколько воркеров сейчас свободно
+func (q *Queue) Idle() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.idle
+}
+
+// Копия задачи по id
+func (q *Queue) Get(id string) (Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, ok := q.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return *task, true
+}
+
+// Забираем завершённую задачу вместе с файлом собранного архива,
+// удалить файл должен тот, кто его забрал
+func (q *Queue) Take(id string) (Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	task, ok := q.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	copied := *task
+	task.result = ""
+	return copied, true
+}
+
+// Задача завершилась и больше не изменится
+func (t *Task) Finished() bool {
+	return t.Status == TaskDone || t.Status == TaskFailed || t.Status == TaskCancelled
+}
+
+// Канал, который закроется когда задача завершится
+func (t *Task) Done() <-chan struct{} {
+	return t.done
+}
+
+// Меняем задачу под блокировкой
+func (q *Queue) update(task *Task, fn func(t *Task)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	fn(task)
+	task.UpdatedAt = time.Now()
+}
+
+// записываем в журнал итог задачи и результат по каждой ссылке
+func (q *Queue) journalResults(task Task) {
+	for _, result := range task.Results {
+		q.journal(JournalEntry{Type: JournalResult, TaskID: task.ID, URL: result.URL, Error: result.Error, Result: &result})
+	}
+	q.journal(JournalEntry{Type: JournalStatus, TaskID: task.ID, Status: task.Status, Error: task.Error, Link: task.Link})
+}
+
+// Случайный идентификатор
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Статус задачи
+func (h *Handler) TaskStatus(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.queue.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Error not such task", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// Отмена задачи: DELETE /tasks/{id} или POST /tasks/{id}/cancel.
+// Ждём пока задача остановится и возвращаем её
+func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	done, err := h.queue.Cancel(id)
+	switch {
+	case errors.Is(err, ErrTaskNotFound):
+		http.Error(w, "Error not such task", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrTaskFinished):
+		http.Error(w, "Error task already finished", http.StatusConflict)
+		return
+	}
+
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		return
+	}
+
+	task, _ := h.queue.Get(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
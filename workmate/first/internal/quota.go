@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// что делаем когда место закончилось
+const (
+	QuotaReject = "reject"
+	QuotaEvict  = "evict"
+)
+
+// Настройки квоты, 0 - без ограничения
+type QuotaConfig struct {
+	MaxBytes      int64  `json:"max_bytes"`
+	OwnerMaxBytes int64  `json:"owner_max_bytes"`
+	Policy        string `json:"policy"` // reject или evict
+}
+
+// занятое место
+type Usage struct {
+	TotalBytes    int64            `json:"total_bytes"`
+	MaxBytes      int64            `json:"max_bytes"`
+	OwnerMaxBytes int64            `json:"owner_max_bytes"`
+	Owners        map[string]int64 `json:"owners"`
+}
+
+// архив с размером, для подсчёта места
+type storedArchive struct {
+	meta ArchiveMeta
+	size int64
+}
+
+// Задаём квоту хранилищу
+func (s *Storage) SetQuota(cfg QuotaConfig) {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+	s.quota = cfg
+}
+
+// Считаем сколько места занято
+func (s *Storage) Usage() (Usage, error) {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	usage := Usage{
+		MaxBytes:      s.quota.MaxBytes,
+		OwnerMaxBytes: s.quota.OwnerMaxBytes,
+		Owners:        make(map[string]int64),
+	}
+
+	archives, err := s.archives()
+	if err != nil {
+		return usage, err
+	}
+
+	for _, archive := range archives {
+		usage.TotalBytes += archive.size
+		if archive.meta.Owner != "" {
+			usage.Owners[archive.meta.Owner] += archive.size
+		}
+	}
+	return usage, nil
+}
+
+// Проверяем что size байт поместится. При политике evict удаляем давно не используемые
+// не закреплённые архивы, архив exclude (тот в который пишем) не трогаем
+func (s *Storage) Ensure(owner string, size int64, exclude string) error {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	if s.quota.MaxBytes <= 0 && s.quota.OwnerMaxBytes <= 0 {
+		return nil
+	}
+
+	archives, err := s.archives()
+	if err != nil {
+		return err
+	}
+
+	// сначала самые давно использованные
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].meta.LastUsed().Before(archives[j].meta.LastUsed())
+	})
+
+	var total, ownerTotal int64
+	for _, archive := range archives {
+		total += archive.size
+		if owner != "" && archive.meta.Owner == owner {
+			ownerTotal += archive.size
+		}
+	}
+
+	overflow := func() bool {
+		if s.quota.MaxBytes > 0 && total+size > s.quota.MaxBytes {
+			return true
+		}
+		return owner != "" && s.quota.OwnerMaxBytes > 0 && ownerTotal+size > s.quota.OwnerMaxBytes
+	}
+
+	for _, archive := range archives {
+		if !overflow() {
+			break
+		}
+		if s.quota.Policy != QuotaEvict {
+			return ErrQuotaExceeded
+		}
+		if archive.meta.Pinned || archive.meta.FileName == exclude {
+			continue
+		}
+
+		// если мешает только квота владельца, чужие архивы не удаляем
+		ownerOnly := s.quota.MaxBytes <= 0 || total+size <= s.quota.MaxBytes
+		if ownerOnly && archive.meta.Owner != owner {
+			continue
+		}
+
+		// архив в работе у другого запроса не трогаем
+		unlock, ok := s.TryLock(archive.meta.FileName)
+		if !ok {
+			continue
+		}
+		// список читали без блокировки: архив могли закрепить или использовать, тогда он уже не самый старый
+		if meta, err := s.ReadMeta(archive.meta.FileName); err != nil || meta.Pinned || meta.LastUsed().After(archive.meta.LastUsed()) {
+			unlock()
+			continue
+		}
+		err := s.Remove(archive.meta.FileName)
+		unlock()
+		if err != nil {
+			log.Printf("Evict %s failed: %v", archive.meta.FileName, err)
+			continue
+		}
+		log.Printf("File evicted: %s (%d bytes)", archive.meta.FileName, archive.size)
+
+		total -= archive.size
+		if owner != "" && archive.meta.Owner == owner {
+			ownerTotal -= archive.size
+		}
+	}
+
+	if overflow() {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (s *Storage) archives() ([]storedArchive, error) {
+	metas, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]storedArchive, 0, len(metas))
+	for _, meta := range metas {
+		info, err := os.Stat(s.Path(meta.FileName))
+		if err != nil {
+			continue
+		}
+		archives = append(archives, storedArchive{meta: meta, size: info.Size()})
+	}
+	return archives, nil
+}
+
+// Сколько места занято и сколько можно
+func (h *Handler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.storage.Usage()
+	if err != nil {
+		http.Error(w, "Error read storage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
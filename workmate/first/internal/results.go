@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+)
+
+// виды ошибок скачивания
+const (
+	ErrorClassInvalidURL   = "invalid_url"        // ссылка не разбирается
+	ErrorClassDNS          = "dns"                // хост не найден
+	ErrorClassTimeout      = "timeout"            // источник не ответил вовремя
+	ErrorClassNetwork      = "network"            // соединение не удалось или оборвалось
+	ErrorClassTLS          = "tls"                // сертификат не прошёл проверку или не договорились о TLS
+	ErrorClassHTTPStatus   = "http_status"        // источник ответил не 200
+	ErrorClassFTPStatus    = "ftp_status"         // ftp сервер ответил ошибкой
+	ErrorClassRedirect     = "redirect"           // редирект запрещён правилами
+	ErrorClassScheme       = "unsupported_scheme" // схему ссылки сервер не скачивает
+	ErrorClassTypeRejected = "type_rejected"      // тип файла не разрешён
+	ErrorClassTooLarge     = "too_large"          // файл больше разрешённого
+	ErrorClassCancelled    = "cancelled"          // задачу отменили
+	ErrorClassWrite        = "write"              // файл скачался, но не записался в архив
+)
+
+// статусы ссылок в отчёте
+const (
+	ResultOK     = "ok"
+	ResultFailed = "failed"
+)
+
+// заголовок-трейлер с отчётом для /downloadandzip
+const ResultsTrailer = "X-Results"
+
+// Ошибка скачивания с видом ошибки и кодом ответа источника
+type DownloadError struct {
+	Class      string
+	StatusCode int
+	Err        error
+}
+
+func (e *DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// Ошибка запроса к источнику: разбираем что именно случилось,
+// если источник сам разобрал ошибку - оставляем её
+func requestError(err error, wrapped error) *DownloadError {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		return downloadErr
+	}
+
+	class := ErrorClassNetwork
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled):
+		class = ErrorClassCancelled
+	case errors.As(err, &dnsErr):
+		class = ErrorClassDNS
+	case errors.As(err, &certErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		class = ErrorClassTLS
+	// источник ответил не по TLS или прислал alert
+	case errors.As(err, &recordErr), errors.As(err, &alertErr):
+		class = ErrorClassTLS
+	// alert от сервера (версия, нет клиентского сертификата) tls отдаёт как OpError "remote error"
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		class = ErrorClassTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		class = ErrorClassTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		class = ErrorClassTimeout
+	}
+	return &DownloadError{Class: class, Err: wrapped}
+}
+
+// вид ошибки и код ответа для отчёта
+func classifyError(err error) (string, int) {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		return downloadErr.Class, downloadErr.StatusCode
+	}
+	return ErrorClassWrite, 0
+}
+
+// Результат по одной ссылке
+type URLResult struct {
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	FinalURL   string `json:"final_url,omitempty"` // куда привели редиректы
+	Name       string `json:"name,omitempty"`      // имя файла в архиве
+	Size       int64  `json:"size,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	HTTPStatus int    `json:"http_status,omitempty"` // код ответа источника
+	Error      string `json:"error,omitempty"`
+}
+
+// Отчёт по задаче: одинаковый для /tasks/{id}, уведомлений и /downloadandzip
+type ResultDocument struct {
+	TaskID   string      `json:"task_id"`
+	Status   string      `json:"status"`
+	FileName string      `json:"filename"`
+	Link     string      `json:"link,omitempty"`
+	Results  []URLResult `json:"results"`
+}
+
+// Отчёт по завершённой задаче
+func (t Task) Document() ResultDocument {
+	results := t.Results
+	if results == nil {
+		results = []URLResult{}
+	}
+	return ResultDocument{TaskID: t.ID, Status: t.Status, FileName: t.FileName, Link: t.Link, Results: results}
+}
+
+// Собираем отчёт по ссылкам: errs - ошибки после записи в архив, в них и ошибки скачивания
+func urlResults(results []DownloadResult, errs []ErrorResponse) []URLResult {
+	failed := make(map[string]ErrorResponse)
+	for _, e := range errs {
+		failed[e.URL] = e
+	}
+
+	report := make([]URLResult, 0, len(results))
+	for _, result := range results {
+		if e, ok := failed[result.URL]; ok {
+			report = append(report, URLResult{
+				URL:        result.URL,
+				Status:     ResultFailed,
+				ErrorClass: e.Class,
+				HTTPStatus: e.HTTPStatus,
+				Error:      e.Error,
+			})
+			continue
+		}
+		report = append(report, URLResult{
+			URL:      result.URL,
+			Status:   ResultOK,
+			FinalURL: result.FinalURL,
+			Name:     result.Filename,
+			Size:     result.Size,
+		})
+	}
+	return report
+}
+
+// Ошибка по ссылке для списка ошибок задачи
+func errorResponse(url string, err error) ErrorResponse {
+	class, status := classifyError(err)
+	return ErrorResponse{URL: url, Error: err.Error(), Class: class, HTTPStatus: status}
+}
+
+// Отчёт задачи в виде json
+func (h *Handler) TaskResults(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.queue.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Error not such task", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task.Document())
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// расширение файла с метаданными архива
+const metaExt = ".meta.json"
+
+// временные файлы называются .<имя>.tmp-<случайное>
+const tmpMarker = ".tmp-"
+
+// метаданные архива, которым управляет сервер
+type ArchiveMeta struct {
+	FileName    string    `json:"filename"`
+	DisplayName string    `json:"display_name,omitempty"` // имя для скачивания, если имя файла сгенерировал сервер
+	CreatedAt   time.Time `json:"created_at"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	AccessedAt  time.Time `json:"accessed_at"`
+	TTL         Duration  `json:"ttl,omitempty"`
+	Pinned      bool      `json:"pinned"`
+	Owner       string    `json:"owner,omitempty"`
+
+	// архив создан с паролем, добавлять файлы можно только с ним
+	PasswordSalt string `json:"password_salt,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// Когда архив последний раз читали или меняли
+func (m ArchiveMeta) LastUsed() time.Time {
+	if m.AccessedAt.After(m.ModifiedAt) {
+		return m.AccessedAt
+	}
+	return m.ModifiedAt
+}
+
+// Хранилище архивов: папка с zip файлами и метаданными рядом с ними
+type Storage struct {
+	dir        string
+	defaultTTL time.Duration
+	quota      QuotaConfig
+	quotaMu    sync.Mutex
+	locks      map[string]*archiveLock
+	locksMu    sync.Mutex
+}
+
+// Создаём хранилище
+func NewStorage(dir string, defaultTTL time.Duration) *Storage {
+	return &Storage{dir: dir, defaultTTL: defaultTTL, locks: make(map[string]*archiveLock)}
+}
+
+// Путь до архива
+func (s *Storage) Path(filename string) string {
+	return filepath.Join(s.dir, filename)
+}
+
+func (s *Storage) metaPath(filename string) string {
+	return s.Path(filename) + metaExt
+}
+
+// Когда архив устареет
+func (s *Storage) ExpiresAt(meta ArchiveMeta) time.Time {
+	ttl := time.Duration(meta.TTL)
+	if ttl <= 0 {
+		ttl = s.defaultTTL
+	}
+	return meta.ModifiedAt.Add(ttl)
+}
+
+// Читаем метаданные архива
+func (s *Storage) ReadMeta(filename string) (ArchiveMeta, error) {
+	var meta ArchiveMeta
+
+	data, err := os.ReadFile(s.metaPath(filename))
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+// Сохраняем метаданные через временный файл, чтобы не оставить половину json
+func (s *Storage) WriteMeta(meta ArchiveMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(s.metaPath(meta.FileName), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Отмечаем что архив изменился, вызывается под Lock архива
+func (s *Storage) Touch(filename string) error {
+	meta, err := s.ReadMeta(filename)
+	if err != nil {
+		return err
+	}
+	meta.ModifiedAt = time.Now()
+	meta.AccessedAt = meta.ModifiedAt
+	return s.WriteMeta(meta)
+}
+
+// Отмечаем что архив читали, нужно для вытеснения давно не используемых.
+// Метаданные меняем под блокировкой архива, иначе можно затереть закрепление.
+// Чтение не ждёт пока архив дописывают: запись сама обновит время через Touch
+func (s *Storage) Access(filename string) error {
+	unlock, ok := s.TryLock(filename)
+	if !ok {
+		return nil
+	}
+	defer unlock()
+
+	meta, err := s.ReadMeta(filename)
+	if err != nil {
+		return err
+	}
+	meta.AccessedAt = time.Now()
+	return s.WriteMeta(meta)
+}
+
+// Удаляем архив вместе с метаданными
+func (s *Storage) Remove(filename string) error {
+	err := os.Remove(s.Path(filename))
+	if rmErr := os.Remove(s.metaPath(filename)); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// Архивы которыми управляет сервер, то есть у которых есть метаданные
+func (s *Storage) List() ([]ArchiveMeta, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []ArchiveMeta
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".zip"+metaExt) {
+			continue
+		}
+
+		filename := strings.TrimSuffix(file.Name(), metaExt)
+		meta, err := s.ReadMeta(filename)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(s.Path(filename)); err != nil {
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// Удаляем временные файлы, оставшиеся после падения сервера
+func (s *Storage) Recover() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), ".") || !strings.Contains(file.Name(), tmpMarker) {
+			continue
+		}
+		if err := os.Remove(s.Path(file.Name())); err != nil {
+			log.Printf("Error remove temp file %s: %v", file.Name(), err)
+			continue
+		}
+		log.Printf("Temp file removed: %s", file.Name())
+	}
+	return nil
+}
+
+// Временный файл в папке архивов для скачанного файла или собранного архива.
+// Если сервер упадёт, его удалит Recover при следующем запуске
+func (s *Storage) Spool(name string) (*os.File, error) {
+	return os.CreateTemp(s.dir, "."+name+tmpMarker+"*")
+}
+
+// Пишем файл целиком во временный файл рядом, сбрасываем на диск и подменяем через rename.
+// Если сервер упадёт посередине, старый файл останется целым
+func writeAtomic(path string, fn func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+tmpMarker+"*")
+	if err != nil {
+		return err
+	}
+	// если что-то пошло не так, временный файл не нужен
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrNoFiles     = errors.New("no files downloaded")
+	ErrUnknownTask = errors.New("unknown task kind")
+)
+
+// Выполняем задачу в воркере очереди
+func (h *Handler) runTask(task *Task) error {
+	switch task.Kind {
+	case TaskDownload:
+		return h.buildArchive(task)
+	case TaskAdd:
+		return h.addToArchive(task)
+	default:
+		return ErrUnknownTask
+	}
+}
+
+// Скачиваем файлы и собираем архив во временный файл в формате задачи
+func (h *Handler) buildArchive(task *Task) error {
+	format, ok := parseFormat(task.Format)
+	if !ok {
+		return fmt.Errorf("unknown format %s", task.Format)
+	}
+
+	// Скачиваем файлы параллельно
+	results := h.downloadFiles(task.ctx, task.ID, task.Priority, taskSources(task))
+	defer removeSpooled(results)
+
+	// задачу отменили, недособранный архив не нужен
+	if err := task.ctx.Err(); err != nil {
+		return err
+	}
+
+	// Создаем архив на диске, он может быть больше памяти
+	file, err := h.storage.Spool(task.FileName)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", format.Name, err)
+	}
+	defer file.Close()
+
+	archive := format.NewWriter(file, h.taskCompression(task), task.password, h.storage.dir)
+
+	if task.Manifest {
+		renameReserved(results)
+	}
+	errs, hasSuccess := writeResults(archive, results)
+
+	if task.Manifest {
+		var manifest Manifest
+		manifest.add(results, errs, time.Now())
+		if err := manifest.write(archive); err != nil {
+			os.Remove(file.Name())
+			return fmt.Errorf("failed to write manifest: %v", err)
+		}
+	}
+	// задачу отменили пока писали архив, клиент его уже не заберёт
+	if err := task.ctx.Err(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	// Закрываем архив
+	if err := archive.Close(); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to create %s: %v", format.Name, err)
+	}
+	if err := task.ctx.Err(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to create %s: %v", format.Name, err)
+	}
+
+	h.queue.update(task, func(t *Task) {
+		t.Errors = errs
+		t.Results = urlResults(results, errs)
+		if hasSuccess {
+			t.result = file.Name()
+		}
+	})
+	h.events.Publish(Event{TaskID: task.ID, Type: EventArchive, Bytes: size})
+
+	if !hasSuccess {
+		os.Remove(file.Name())
+		return ErrNoFiles
+	}
+	return nil
+}
+
+// Скачиваем файлы и дописываем в архив на сервере
+func (h *Handler) addToArchive(task *Task) error {
+	filename := task.FileName
+
+	// Скачиваем файлы параллельно, архив на это время не блокируем
+	results := h.downloadFiles(task.ctx, task.ID, task.Priority, taskSources(task))
+	defer removeSpooled(results)
+	if err := task.ctx.Err(); err != nil {
+		return err
+	}
+
+	var errs []ErrorResponse
+	var hasSuccess bool
+	defer func() {
+		h.queue.update(task, func(t *Task) {
+			t.Errors = errs
+			t.Results = urlResults(results, errs)
+		})
+	}()
+
+	for _, result := range results {
+		if result.Error != nil {
+			errs = append(errs, errorResponse(result.URL, result.Error))
+		}
+	}
+
+	// Если ни один файл не скачался
+	if len(errs) == len(results) {
+		return ErrNoFiles
+	}
+
+	unlock := h.storage.Lock(filename)
+	defer unlock()
+
+	// Проверяем квоту до записи по размеру скачанного. Это оценка: несжимаемые файлы
+	// и шифрование добавляют к нему заголовки записей и служебные байты AES
+	var size int64
+	for _, result := range results {
+		size += result.Size
+	}
+	meta, _ := h.storage.ReadMeta(filename)
+	if err := h.storage.Ensure(meta.Owner, size, filename); err != nil {
+		return err
+	}
+
+	// манифест ведётся если его попросили сейчас или он уже есть в архиве
+	manifest, hasManifest, err := readManifest(h.storage.Path(filename), task.password)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+	hasManifest = hasManifest || task.Manifest
+	if hasManifest {
+		renameReserved(results)
+	}
+
+	// старые manifest.json и SHA256SUMS заменяются новыми
+	keep := func(f *zip.File) (string, bool) {
+		return f.Name, !hasManifest || !isManifestEntry(f.Name)
+	}
+
+	// Пересобираем архив во временный файл: старые файлы + новые
+	// если задачу отменили во время записи, временный файл удаляется и архив остаётся прежним
+	err = rewriteZip(h.storage.Path(filename), keep, func(zipWriter *zip.Writer) error {
+		archive := newZipArchive(zipWriter, h.taskCompression(task), task.password, h.storage.dir)
+		errs, hasSuccess = writeResults(archive, results)
+		if hasManifest {
+			manifest.add(results, errs, time.Now())
+			if err := manifest.write(archive); err != nil {
+				return err
+			}
+		}
+		return task.ctx.Err()
+	})
+	if err := task.ctx.Err(); err != nil {
+		return err
+	}
+	if os.IsNotExist(err) {
+		return fmt.Errorf("archive %s not found", filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write zip: %v", err)
+	}
+
+	// Если ни один файл не записался
+	if !hasSuccess {
+		return ErrNoFiles
+	}
+
+	// архив изменился, продлеваем ему жизнь
+	h.storage.Touch(filename)
+
+	if info, err := os.Stat(h.storage.Path(filename)); err == nil {
+		h.events.Publish(Event{TaskID: task.ID, Type: EventArchive, Bytes: info.Size()})
+	}
+
+	h.queue.update(task, func(t *Task) { t.Link = "/archives/" + filename })
+	return nil
+}
+
+// Политика сжатия задачи: настройки сервера с поправками из запроса
+func (h *Handler) taskCompression(task *Task) Compression {
+	compression, _ := h.compression.Override(task.Compression, task.Level)
+	return compression
+}
+
+// Добавляем скачанные файлы в архив, возвращаем ошибки по ссылкам
+// и удалось ли добавить хоть один файл
+func writeResults(archive ArchiveWriter, results []DownloadResult) ([]ErrorResponse, bool) {
+	var errors []ErrorResponse
+	var hasSuccess bool
+
+	now := time.Now()
+	for _, result := range results {
+		if result.Error != nil {
+			errors = append(errors, errorResponse(result.URL, result.Error))
+			continue
+		}
+
+		// Создаем файл в архиве и копируем содержимое с диска
+		if err := addSpooled(archive, result, now); err != nil {
+			errors = append(errors, errorResponse(result.URL, fmt.Errorf("failed to write to archive: %v", err)))
+			continue
+		}
+
+		hasSuccess = true
+	}
+	return errors, hasSuccess
+}
+
+// Добавляем в архив скачанный файл из временного файла
+func addSpooled(archive ArchiveWriter, result DownloadResult, modified time.Time) error {
+	file, err := os.Open(result.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return archive.Add(result.Filename, result.Size, modified, file)
+}
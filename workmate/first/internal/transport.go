@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Настройки соединений для скачивания по http и https
+type TransportConfig struct {
+	// прокси для всех ссылок: http://, https:// или socks5://host:port
+	Proxy string `json:"proxy"`
+	// если proxy не задан, берём его из HTTP_PROXY, HTTPS_PROXY и NO_PROXY
+	ProxyFromEnv bool `json:"proxy_from_env"`
+
+	RootCAs       []string `json:"root_cas"`        // файлы PEM с сертификатами, которым доверяем вместе с системными
+	ClientCert    string   `json:"client_cert"`     // сертификат (PEM) для источников, которые требуют mTLS
+	ClientKey     string   `json:"client_key"`      // его ключ
+	MinTLSVersion string   `json:"min_tls_version"` // "1.2" или "1.3", пустой - по умолчанию Go (1.2)
+
+	// соединения держим открытыми между запросами и задачами, 0 - значения по умолчанию
+	MaxIdleConns        int      `json:"max_idle_conns"`          // простаивающих на все хосты, по умолчанию 100
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host"` // простаивающих на один хост, по умолчанию 10
+	MaxConnsPerHost     int      `json:"max_conns_per_host"`      // всего на один хост, по умолчанию без ограничения
+	IdleConnTimeout     Duration `json:"idle_conn_timeout"`       // когда закрываем простаивающее, по умолчанию 90s
+
+	DialTimeout           Duration `json:"dial_timeout"`            // установка соединения, по умолчанию 30s
+	TLSHandshakeTimeout   Duration `json:"tls_handshake_timeout"`   // рукопожатие TLS, по умолчанию 10s
+	ResponseHeaderTimeout Duration `json:"response_header_timeout"` // ожидание заголовков ответа, по умолчанию без ограничения
+	DisableHTTP2          bool     `json:"disable_http2"`           // только HTTP/1.1, даже если источник умеет HTTP/2
+}
+
+// Прокси для запросов по настройкам
+func (c TransportConfig) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("invalid proxy scheme: %s", proxyURL.Scheme)
+		}
+		return http.ProxyURL(proxyURL), nil
+	}
+	if c.ProxyFromEnv {
+		return http.ProxyFromEnvironment, nil
+	}
+	return nil, nil
+}
+
+// Настройки TLS: свои корневые сертификаты, клиентский сертификат и минимальная версия
+func (c TransportConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	switch c.MinTLSVersion {
+	case "":
+	case "1.0":
+		config.MinVersion = tls.VersionTLS10
+	case "1.1":
+		config.MinVersion = tls.VersionTLS11
+	case "1.2":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid min tls version: %s", c.MinTLSVersion)
+	}
+
+	if len(c.RootCAs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range c.RootCAs {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("read root ca: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates in %s", file)
+			}
+		}
+		config.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Общий транспорт для всех скачиваний: соединения переиспользуются между запросами
+func newTransport(c TransportConfig) (*http.Transport, error) {
+	proxy, err := c.proxy()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   orDefault(c.DialTimeout, 30*time.Second),
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       orDefault(c.IdleConnTimeout, 90*time.Second),
+		TLSHandshakeTimeout:   orDefault(c.TLSHandshakeTimeout, 10*time.Second),
+		ResponseHeaderTimeout: time.Duration(c.ResponseHeaderTimeout),
+		ExpectContinueTimeout: time.Second,
+		// со своим DialContext и TLSClientConfig HTTP/2 надо включать явно
+		ForceAttemptHTTP2: !c.DisableHTTP2,
+	}
+	if transport.MaxIdleConns == 0 {
+		transport.MaxIdleConns = 100
+	}
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = 10
+	}
+	if c.DisableHTTP2 {
+		// пустая, но не nil таблица протоколов отключает HTTP/2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport, nil
+}
+
+// Длительность из настроек или значение по умолчанию, если она не задана
+func orDefault(d Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+	return def
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// заголовок с подписью тела уведомления
+const SignatureHeader = "X-Signature"
+
+// Настройки уведомлений о готовности архива
+type WebhookConfig struct {
+	Secret   string   `json:"secret"`   // ключ для подписи HMAC-SHA256
+	Attempts int      `json:"attempts"` // сколько раз пробуем доставить
+	Backoff  Duration `json:"backoff"`  // пауза перед второй попыткой, дальше удваивается
+	BaseURL  string   `json:"base_url"` // адрес сервера для ссылки на архив
+}
+
+// Тело уведомления
+type WebhookPayload struct {
+	TaskID      string          `json:"task_id"`
+	Status      string          `json:"status"`
+	FileName    string          `json:"filename"`
+	DisplayName string          `json:"display_name,omitempty"`
+	Link        string          `json:"link,omitempty"`
+	Errors      []ErrorResponse `json:"errors,omitempty"`
+	Results     []URLResult     `json:"results,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// Попытка доставки уведомления
+type Delivery struct {
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Отправляет уведомления о завершении задач на callback_url
+type Notifier struct {
+	cfg    WebhookConfig
+	client *http.Client
+
+	mu         sync.Mutex
+	deliveries map[string][]Delivery
+}
+
+// Создаём отправителя уведомлений
+func NewNotifier(cfg WebhookConfig) *Notifier {
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = 1
+	}
+	return &Notifier{
+		cfg:        cfg,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		deliveries: make(map[string][]Delivery),
+	}
+}
+
+// Отправляем уведомление в фоне, если у задачи есть callback_url
+func (n *Notifier) Notify(task Task) {
+	if task.CallbackURL == "" {
+		return
+	}
+
+	payload := WebhookPayload{
+		TaskID:      task.ID,
+		Status:      task.Status,
+		FileName:    task.FileName,
+		DisplayName: task.DisplayName,
+		Errors:      task.Errors,
+		Results:     task.Results,
+		Error:       task.Error,
+	}
+	if task.Link != "" {
+		payload.Link = n.cfg.BaseURL + task.Link
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encode webhook: %v", err)
+		return
+	}
+
+	go n.deliver(task.ID, task.CallbackURL, body)
+}
+
+// Пробуем доставить с паузами, каждая следующая пауза в два раза больше
+func (n *Notifier) deliver(taskID string, callbackURL string, body []byte) {
+	backoff := time.Duration(n.cfg.Backoff)
+
+	for attempt := 1; attempt <= n.cfg.Attempts; attempt++ {
+		delivery := Delivery{Attempt: attempt, Time: time.Now()}
+
+		statusCode, err := n.send(callbackURL, body)
+		delivery.StatusCode = statusCode
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		n.mu.Lock()
+		n.deliveries[taskID] = append(n.deliveries[taskID], delivery)
+		n.mu.Unlock()
+
+		if err == nil {
+			return
+		}
+		if attempt < n.cfg.Attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.Printf("Webhook for task %s not delivered", taskID)
+}
+
+func (n *Notifier) send(callbackURL string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(n.cfg.Secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver returned: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Попытки доставки уведомления по задаче
+func (n *Notifier) Deliveries(taskID string) []Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Delivery{}, n.deliveries[taskID]...)
+}
+
+// Забываем попытки доставки по задаче, которую забыла очередь
+func (n *Notifier) Forget(taskID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.deliveries, taskID)
+}
+
+// Подпись тела: "sha256=" + hex(HMAC-SHA256(secret, body))
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// callback_url должен быть http или https адресом
+func validCallbackURL(callbackURL string) bool {
+	u, err := url.Parse(callbackURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Журнал доставки уведомлений по задаче
+func (h *Handler) TaskDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := h.queue.Get(id); !ok {
+		http.Error(w, "Error not such task", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.notifier.Deliveries(id))
+}
@@ -2,11 +2,15 @@ package internal
 
 import (
 	"archive/zip"
-	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,20 +20,86 @@ import (
 	"time"
 )
 
-// Создаём обработчик
-func NewHandler(limiter *RateLimiter, limiterdownload *RateLimiter) *Handler {
-	return &Handler{limiter: limiter, limiterdownload: limiterdownload}
+// Создаём обработчик, архивы по умолчанию лежат в текущей папке.
+// Задачи на сборку архивов выполняют воркеры очереди
+func NewHandler(queue *Queue, limiterdownload *RateLimiter) *Handler {
+	cfg := DefaultConfig()
+	transport, err := newTransport(cfg.Downloads.Transport)
+	if err != nil {
+		// с настройками по умолчанию не бывает, но без транспорта не скачать ни одной ссылки
+		log.Printf("Error default transport: %v", err)
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	h := &Handler{
+		queue:           queue,
+		limiterdownload: limiterdownload,
+		storage:         NewStorage(cfg.Dir, time.Duration(cfg.DefaultTTL)),
+		notifier:        NewNotifier(cfg.Webhook),
+		events:          NewBus(),
+		compression:     NewCompression(cfg.Compression),
+		downloads:       cfg.Downloads,
+		fetchers:        defaultFetchers(newHTTPFetcher(transport, cfg.Downloads.Redirects)),
+		transport:       transport,
+	}
+	queue.OnFinish(func(task Task) {
+		h.events.Publish(Event{TaskID: task.ID, Type: EventFinished, Status: task.Status, Error: task.Error})
+		h.notifier.Notify(task)
+	})
+	queue.OnPrune(func(id string) {
+		h.notifier.Forget(id)
+	})
+	queue.Start(h.runTask)
+	return h
 }
 
-// Скачиваем архивируем и сразу возвращаем zip
-func (h *Handler) DownloadAndZip(w http.ResponseWriter, r *http.Request) {
-	err := h.limiter.TryAcquire()
+// Меняем хранилище архивов
+func (h *Handler) SetStorage(storage *Storage) {
+	h.storage = storage
+}
+
+// Меняем отправителя уведомлений
+func (h *Handler) SetNotifier(notifier *Notifier) {
+	h.notifier = notifier
+}
+
+// Меняем настройки скачивания, ошибка - если не удалось прочитать сертификаты или прокси
+func (h *Handler) SetDownloads(cfg DownloadsConfig) error {
+	transport, err := newTransport(cfg.Transport)
 	if err != nil {
-		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
-		return
+		return err
+	}
+	web := newHTTPFetcher(transport, cfg.Redirects)
+	h.downloads = cfg
+	h.fetchers["http"] = web
+	h.fetchers["https"] = web
+	if h.s3 != nil {
+		h.fetchers["s3"] = newS3Fetcher(*h.s3, transport)
 	}
-	defer h.limiter.Release()
 
+	// соединения старого транспорта больше не понадобятся
+	h.transport.CloseIdleConnections()
+	h.transport = transport
+	return nil
+}
+
+// Скачиваем ссылки s3:// из хранилища cfg, запросы идут через общий транспорт
+func (h *Handler) SetS3(cfg S3Config) {
+	h.s3 = &cfg
+	h.fetchers["s3"] = newS3Fetcher(cfg, h.transport)
+}
+
+// Скачиваем ссылки схемы scheme через свой fetcher
+func (h *Handler) SetFetcher(scheme string, fetcher Fetcher) {
+	h.fetchers[scheme] = fetcher
+}
+
+// Меняем политику сжатия по умолчанию
+func (h *Handler) SetCompression(cfg CompressionConfig) {
+	h.compression = NewCompression(cfg)
+}
+
+// Скачиваем архивируем и сразу возвращаем zip
+func (h *Handler) DownloadAndZip(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -47,139 +117,230 @@ func (h *Handler) DownloadAndZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filename := time.Now().Format("20060102_1504") + ".zip"
-	if req.FileName != "" {
-		filename = req.FileName
-		if !strings.HasSuffix(filename, ".zip") {
-			filename += ".zip"
-		}
+	format, ok := parseFormat(req.Format)
+	if !ok {
+		http.Error(w, "Error format", http.StatusBadRequest)
+		return
 	}
 
-	// Скачиваем файлы параллельно
-	results := h.downloadFiles(req.URLs)
-
-	// Создаем ZIP архив в памяти
-	zipBuffer := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(zipBuffer)
+	if req.Password != "" && format.Name != FormatZip {
+		http.Error(w, "Error password: only zip archives are encrypted", http.StatusBadRequest)
+		return
+	}
 
-	var errors []ErrorResponse
-	var hasSuccess bool
+	// архив никуда не сохраняется, имя нужно только чтобы различать задачи,
+	// пользователю отдаём его под своим именем
+	filename := format.FileName(newULID())
+	displayName := filename
+	if req.FileName != "" {
+		displayName = format.FileName(req.FileName)
+	}
 
-	// Добавляем файлы в архив
-	for _, result := range results {
-		if result.Error != nil {
-			errors = append(errors, ErrorResponse{
-				URL:   result.URL,
-				Error: result.Error.Error(),
-			})
-			continue
-		}
+	if req.CallbackURL != "" && !validCallbackURL(req.CallbackURL) {
+		http.Error(w, "Error callback url", http.StatusBadRequest)
+		return
+	}
 
-		// Создаем файл в архиве
-		writer, err := zipWriter.Create(result.Filename)
-		if err != nil {
-			errors = append(errors, ErrorResponse{
-				URL:   result.URL,
-				Error: fmt.Sprintf("failed to add to zip: %v", err),
-			})
-			continue
-		}
+	priority, ok := parsePriority(req.Priority)
+	if !ok {
+		http.Error(w, "Error priority", http.StatusBadRequest)
+		return
+	}
 
-		// Копируем содержимое
-		if _, err := io.Copy(writer, bytes.NewReader(result.Content)); err != nil {
-			errors = append(errors, ErrorResponse{
-				URL:   result.URL,
-				Error: fmt.Sprintf("failed to write to zip: %v", err),
-			})
-			continue
-		}
+	if _, ok := h.compression.Override(req.Compression, req.Level); !ok {
+		http.Error(w, "Error compression", http.StatusBadRequest)
+		return
+	}
 
-		hasSuccess = true
+	// Архив собирает воркер, ждём его
+	sources := requestSources(req)
+	task := &Task{
+		Kind:        TaskDownload,
+		FileName:    filename,
+		DisplayName: displayName,
+		Format:      format.Name,
+		Compression: req.Compression,
+		Level:       req.Level,
+		Encrypted:   req.Password != "",
+		Manifest:    req.Manifest,
+		URLs:        sourceURLs(sources),
+		Secrets:     hasSecrets(sources),
+		CallbackURL: req.CallbackURL,
+		Priority:    priority,
+		password:    req.Password,
+		sources:     sources,
+	}
+	if err := h.queue.Submit(task); err != nil {
+		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
+		return
 	}
 
-	// Закрываем архив
-	if err := zipWriter.Close(); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create zip: %v", err), http.StatusInternalServerError)
+	// клиент ушёл не дождавшись архива, собирать его больше незачем
+	select {
+	case <-task.Done():
+	case <-r.Context().Done():
+		h.queue.Cancel(task.ID)
+		// ждём воркера: он мог дописывать архив, и файл появится только после завершения
+		<-task.Done()
+		if result, ok := h.queue.Take(task.ID); ok && result.result != "" {
+			os.Remove(result.result)
+		}
 		return
 	}
 
-	// Если ни один файл не скачался
-	if !hasSuccess {
+	result, _ := h.queue.Take(task.ID)
+	if result.result != "" {
+		defer os.Remove(result.result)
+	}
+
+	// Если ни один файл не скачался, отдаём отчёт по ссылкам
+	if result.Status != TaskDone {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusPartialContent)
-		json.NewEncoder(w).Encode(errors)
+		json.NewEncoder(w).Encode(result.Document())
 		return
 	}
 
-	// Отправляем архив или ошибки
-	if len(errors) > 0 {
+	// Отправляем архив, отчёт по ссылкам приходит в трейлере после архива
+	if len(result.Errors) > 0 {
 		w.Header().Set("X-Errors", "true")
 	}
+	w.Header().Set("Trailer", ResultsTrailer)
 
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-	io.Copy(w, zipBuffer)
-}
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", contentDisposition(displayName))
+	w.Header().Set("X-Task-Id", task.ID)
 
-// Создаём архив
-func (h *Handler) CreateZip(w http.ResponseWriter, r *http.Request) {
-	err := h.limiter.TryAcquire()
+	// Потоковая отправка с диска
+	file, err := os.Open(result.result)
 	if err != nil {
-		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
+		http.Error(w, "File error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Download interrupted: %v", err)
 		return
 	}
-	defer h.limiter.Release()
 
+	report, _ := json.Marshal(result.Document())
+	w.Header().Set(ResultsTrailer, string(report))
+}
+
+// Создаём архив
+func (h *Handler) CreateZip(w http.ResponseWriter, r *http.Request) {
 	var req Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if req.FileName == "" {
-		http.Error(w, "Error file name", http.StatusBadRequest)
+	// архивы на сервере только zip: их файлы можно смотреть, удалять и перемещать
+	if req.Format != "" && req.Format != FormatZip {
+		http.Error(w, "Error format: only zip archives are stored", http.StatusBadRequest)
 		return
 	}
 
-	filename := req.FileName
-	if !strings.HasSuffix(filename, ".zip") {
-		filename += ".zip"
+	// без имени или по просьбе клиента имя придумывает сервер,
+	// имя клиента остаётся только для скачивания
+	var filename, displayName string
+	if req.FileName == "" || req.GenerateName {
+		filename = newULID() + ".zip"
+		if req.FileName != "" {
+			displayName = zipName(req.FileName)
+		}
+	} else {
+		if !validName(req.FileName) {
+			http.Error(w, "Error file name", http.StatusBadRequest)
+			return
+		}
+		filename = zipName(req.FileName)
+	}
+
+	// время жизни архива, если не задано - берём из настроек
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "Error ttl", http.StatusBadRequest)
+			return
+		}
 	}
 
-	if _, err = os.Open(filename); err == nil {
+	unlock := h.storage.Lock(filename)
+	defer unlock()
+
+	if _, err := os.Stat(h.storage.Path(filename)); err == nil {
 		http.Error(w, "Error file name", http.StatusBadRequest)
 		return
 	}
 
-	file, err := os.Create(filename)
+	// проверяем что место ещё есть
+	if err := h.storage.Ensure(req.Owner, 0, ""); err != nil {
+		http.Error(w, "Storage quota exceeded", http.StatusInsufficientStorage)
+		return
+	}
+
+	if err := createEmptyZip(h.storage.Path(filename)); err != nil {
+		http.Error(w, "Error create zip", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+
+	// пустой манифест, дальше /addtozip будет его дописывать
+	if req.Manifest {
+		err := appendZip(h.storage.Path(filename), func(zipWriter *zip.Writer) error {
+			manifest := Manifest{UpdatedAt: now}
+			return manifest.write(newZipArchive(zipWriter, h.compression, req.Password, h.storage.dir))
+		})
+		if err != nil {
+			os.Remove(h.storage.Path(filename))
+			http.Error(w, "Error create zip", http.StatusInternalServerError)
+			return
+		}
+	}
+	meta := ArchiveMeta{
+		FileName:    filename,
+		CreatedAt:   now,
+		ModifiedAt:  now,
+		AccessedAt:  now,
+		TTL:         Duration(ttl),
+		Owner:       req.Owner,
+		DisplayName: displayName,
+	}
+
+	// пароль не храним, только хэш для проверки при добавлении файлов
+	if req.Password != "" {
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		meta.PasswordSalt = hex.EncodeToString(salt)
+		meta.PasswordHash = hashPassword(req.Password, salt)
+	}
+
+	err := h.storage.WriteMeta(meta)
 	if err != nil {
 		http.Error(w, "Error create zip", http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"filename": filename,
+		"filename":     filename,
+		"display_name": h.storage.DisplayName(filename),
 	})
 }
 
-// Добавляем файлы в архив
+// Добавляем файлы в архив: ставим задачу в очередь и сразу отвечаем её id
 func (h *Handler) AddToZip(w http.ResponseWriter, r *http.Request) {
-	err := h.limiter.TryAcquire()
-	if err != nil {
-		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
-		return
-	}
-	defer h.limiter.Release()
-
 	var req Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if req.FileName == "" {
+	if !validName(req.FileName) {
 		http.Error(w, "Error file name", http.StatusBadRequest)
 		return
 	}
@@ -189,16 +350,20 @@ func (h *Handler) AddToZip(w http.ResponseWriter, r *http.Request) {
 		filename += ".zip"
 	}
 
-	// открываем архив
-	file, err := os.Open(filename)
-	if err != nil {
+	// проверяем что архив есть
+	if _, err := os.Stat(h.storage.Path(filename)); err != nil {
 		http.Error(w, "Error not such file", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	zipWriter := zip.NewWriter(file)
-	defer zipWriter.Close()
+	// в архив с паролем файлы добавляются только с тем же паролем
+	if meta, err := h.storage.ReadMeta(filename); err == nil && meta.PasswordHash != "" {
+		salt, _ := hex.DecodeString(meta.PasswordSalt)
+		if req.Password == "" || hashPassword(req.Password, salt) != meta.PasswordHash {
+			http.Error(w, "Error password", http.StatusForbidden)
+			return
+		}
+	}
 
 	// Парсим входящий JSON
 	if len(req.URLs) == 0 {
@@ -206,73 +371,63 @@ func (h *Handler) AddToZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Скачиваем файлы параллельно
-	results := h.downloadFiles(req.URLs)
-
-	var errors []ErrorResponse
-	var hasSuccess bool
-
-	// Добавляем файлы в архив
-	for _, result := range results {
-		if result.Error != nil {
-			errors = append(errors, ErrorResponse{
-				URL:   result.URL,
-				Error: result.Error.Error(),
-			})
-			continue
-		}
-
-		// Создаем файл в архиве
-		writer, err := zipWriter.Create(result.Filename)
-		if err != nil {
-			errors = append(errors, ErrorResponse{
-				URL:   result.URL,
-				Error: fmt.Sprintf("failed to add to zip: %v", err),
-			})
-			continue
-		}
-
-		// Копируем содержимое
-		if _, err := io.Copy(writer, bytes.NewReader(result.Content)); err != nil {
-			errors = append(errors, ErrorResponse{
-				URL:   result.URL,
-				Error: fmt.Sprintf("failed to write to zip: %v", err),
-			})
-			continue
-		}
-
-		hasSuccess = true
+	if req.CallbackURL != "" && !validCallbackURL(req.CallbackURL) {
+		http.Error(w, "Error callback url", http.StatusBadRequest)
+		return
 	}
 
-	// Если ни один файл не скачался
-	if !hasSuccess {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusPartialContent)
-		json.NewEncoder(w).Encode(errors)
+	priority, ok := parsePriority(req.Priority)
+	if !ok {
+		http.Error(w, "Error priority", http.StatusBadRequest)
 		return
 	}
 
-	if len(errors) > 0 {
-		w.Header().Set("X-Errors", "true")
+	if _, ok := h.compression.Override(req.Compression, req.Level); !ok {
+		http.Error(w, "Error compression", http.StatusBadRequest)
+		return
 	}
-}
 
-// Отправляем архив
-func (h *Handler) DownloadZip(w http.ResponseWriter, r *http.Request) {
-	err := h.limiter.TryAcquire()
-	if err != nil {
+	displayName := h.storage.DisplayName(filename)
+	sources := requestSources(req)
+	task := &Task{
+		Kind:        TaskAdd,
+		FileName:    filename,
+		DisplayName: displayName,
+		Compression: req.Compression,
+		Level:       req.Level,
+		Encrypted:   req.Password != "",
+		Manifest:    req.Manifest,
+		URLs:        sourceURLs(sources),
+		Secrets:     hasSecrets(sources),
+		CallbackURL: req.CallbackURL,
+		Priority:    priority,
+		password:    req.Password,
+		sources:     sources,
+	}
+	if err := h.queue.Submit(task); err != nil {
 		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
 		return
 	}
-	defer h.limiter.Release()
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/tasks/"+task.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"task_id":      task.ID,
+		"filename":     filename,
+		"display_name": displayName,
+	})
+}
+
+// Отправляем архив
+func (h *Handler) DownloadZip(w http.ResponseWriter, r *http.Request) {
 	var req Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if req.FileName == "" {
+	if !validName(req.FileName) {
 		http.Error(w, "Error file name", http.StatusBadRequest)
 		return
 	}
@@ -282,8 +437,24 @@ func (h *Handler) DownloadZip(w http.ResponseWriter, r *http.Request) {
 		filename += ".zip"
 	}
 
+	h.serveArchive(w, filename)
+}
+
+// Отдаём архив по ссылке из статуса задачи
+func (h *Handler) GetArchive(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validName(name) {
+		http.Error(w, "Error file name", http.StatusBadRequest)
+		return
+	}
+
+	h.serveArchive(w, zipName(name))
+}
+
+// Потоково отдаём архив с диска
+func (h *Handler) serveArchive(w http.ResponseWriter, filename string) {
 	// открываем архив
-	file, err := os.Open(filename)
+	file, err := os.Open(h.storage.Path(filename))
 	if err != nil {
 		http.Error(w, "Error not such file", http.StatusBadRequest)
 		return
@@ -296,10 +467,11 @@ func (h *Handler) DownloadZip(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "File error", http.StatusInternalServerError)
 		return
 	}
+	h.storage.Access(filename)
 
 	// Устанавливаем заголовки
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=archive.zip")
+	w.Header().Set("Content-Disposition", contentDisposition(h.storage.DisplayName(filename)))
 	w.Header().Set("Content-Length", fmt.Sprint(fileInfo.Size()))
 
 	// Потоковая отправка (экономит память)
@@ -312,20 +484,13 @@ func (h *Handler) DownloadZip(w http.ResponseWriter, r *http.Request) {
 
 // Отправляем и удаляем архив
 func (h *Handler) DownloadZipAndDelete(w http.ResponseWriter, r *http.Request) {
-	err := h.limiter.TryAcquire()
-	if err != nil {
-		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
-		return
-	}
-	defer h.limiter.Release()
-
 	var req Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if req.FileName == "" {
+	if !validName(req.FileName) {
 		http.Error(w, "Error file name", http.StatusBadRequest)
 		return
 	}
@@ -335,8 +500,12 @@ func (h *Handler) DownloadZipAndDelete(w http.ResponseWriter, r *http.Request) {
 		filename += ".zip"
 	}
 
+	// пока отдаём архив, в него никто не пишет
+	unlock := h.storage.Lock(filename)
+	defer unlock()
+
 	// открываем архив
-	file, err := os.Open(filename)
+	file, err := os.Open(h.storage.Path(filename))
 	if err != nil {
 		http.Error(w, "Error not such file", http.StatusBadRequest)
 		return
@@ -352,7 +521,7 @@ func (h *Handler) DownloadZipAndDelete(w http.ResponseWriter, r *http.Request) {
 
 	// Устанавливаем заголовки
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=archive.zip")
+	w.Header().Set("Content-Disposition", contentDisposition(h.storage.DisplayName(filename)))
 	w.Header().Set("Content-Length", fmt.Sprint(fileInfo.Size()))
 
 	// Потоковая отправка (экономит память)
@@ -363,68 +532,108 @@ func (h *Handler) DownloadZipAndDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Удаление файла ПОСЛЕ успешной отправки
-	err = os.Remove(filename)
+	err = h.storage.Remove(filename)
 	if err != nil {
 		log.Printf("Failed to delete file: %v", err)
 	}
 }
 
 // Скачиваем все файлы по ссылкам
-func (h *Handler) downloadFiles(urls []string) []DownloadResult {
+func (h *Handler) downloadFiles(ctx context.Context, taskID string, priority string, sources []Source) []DownloadResult {
 	var wg sync.WaitGroup
-	results := make([]DownloadResult, len(urls))
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			Proxy: nil, // Отключаем прокси
-		},
-	}
+	results := make([]DownloadResult, len(sources))
 
-	for i, urln := range urls {
+	for i, source := range sources {
 		wg.Add(1)
-		go func(i int, urln string) {
-			h.limiterdownload.Acquire()
-			defer h.limiterdownload.Release()
-
+		go func(i int, source Source) {
 			defer wg.Done()
+			// в отчёты, события и манифест ссылка попадает без пароля
+			urln := redactURL(source.URL)
 			result := DownloadResult{URL: urln}
 
+			// хост нужен лимитеру, ошибку разбора сообщим после получения слота
+			u, parseErr := url.Parse(source.URL)
+			var host string
+			if parseErr == nil {
+				host = strings.ToLower(u.Hostname())
+			}
+
+			// ждём свободный слот, если задачу отменили - не ждём
+			if err := h.limiterdownload.AcquireHost(ctx, priority, host); err != nil {
+				results[i] = DownloadResult{URL: urln, Error: &DownloadError{Class: ErrorClassCancelled, Err: fmt.Errorf("download cancelled: %v", err)}}
+				return
+			}
+			defer h.limiterdownload.ReleaseHost(host)
+
+			// о результате сообщаем подписчикам задачи
+			h.events.Publish(Event{TaskID: taskID, Type: EventStarted, URL: urln})
+			defer func() { h.publishResult(taskID, results[i]) }()
+
 			// Валидация URL
-			if _, err := url.ParseRequestURI(urln); err != nil {
-				result.Error = fmt.Errorf("invalid URL")
+			if parseErr != nil || u.Scheme == "" {
+				result.Error = &DownloadError{Class: ErrorClassInvalidURL, Err: fmt.Errorf("invalid URL")}
+				results[i] = result
+				return
+			}
+			fetcher, ok := h.fetchers[strings.ToLower(u.Scheme)]
+			if !ok {
+				result.Error = &DownloadError{Class: ErrorClassScheme, Err: fmt.Errorf("unsupported scheme: %s", u.Scheme)}
 				results[i] = result
 				return
 			}
 
-			// Скачивание файла
-			resp, err := client.Get(urln)
+			// Скачивание файла, отмена задачи обрывает запрос
+			resp, err := fetcher.Fetch(ctx, u, h.fetchOptions(source, host))
 			if err != nil {
-				result.Error = fmt.Errorf("download failed: %v", err)
+				result.Error = requestError(err, fmt.Errorf("download failed: %v", err))
 				results[i] = result
 				return
 			}
 			defer resp.Body.Close()
 
-			if resp.StatusCode != http.StatusOK {
-				result.Error = fmt.Errorf("server returned: %s", resp.Status)
+			// Проверяем тип и размер до скачивания
+			if !h.allowedType(resp.ContentType) {
+				result.Error = &DownloadError{
+					Class: ErrorClassTypeRejected,
+					Err:   fmt.Errorf("content type not allowed: %s", resp.ContentType),
+				}
+				results[i] = result
+				return
+			}
+			if h.downloads.MaxBytes > 0 && resp.Size > h.downloads.MaxBytes {
+				result.Error = &DownloadError{Class: ErrorClassTooLarge, Err: fmt.Errorf("file is too large: %d bytes", resp.Size)}
 				results[i] = result
 				return
 			}
 
-			// Чтение содержимого
-			content, err := io.ReadAll(resp.Body)
+			// Пишем содержимое во временный файл с отправкой прогресса, в памяти файл целиком не держим.
+			// размер мог быть не указан, поэтому читаем не больше лимита и ещё байт
+			var reader io.Reader = resp.Body
+			if h.downloads.MaxBytes > 0 {
+				reader = io.LimitReader(resp.Body, h.downloads.MaxBytes+1)
+			}
+			body := &progressReader{reader: reader, report: func(read int64) {
+				h.events.Publish(Event{TaskID: taskID, Type: EventProgress, URL: urln, Bytes: read, Total: resp.Size})
+			}}
+			path, size, sum, err := h.spool(taskID, body)
 			if err != nil {
-				result.Error = fmt.Errorf("failed to read content: %v", err)
+				result.Error = requestError(err, fmt.Errorf("failed to read content: %v", err))
+				results[i] = result
+				return
+			}
+			if h.downloads.MaxBytes > 0 && size > h.downloads.MaxBytes {
+				os.Remove(path)
+				result.Error = &DownloadError{Class: ErrorClassTooLarge, Err: fmt.Errorf("file is too large: more than %d bytes", h.downloads.MaxBytes)}
 				results[i] = result
 				return
 			}
 
 			// Определение имени файла
-			filename := filepath.Base(urln)
-			if filename == "." || filename == "/" {
+			filename := resp.Name
+			if filename == "" || filename == "." || filename == "/" {
 				filename = fmt.Sprintf("file_%d", i)
-				if contentType := resp.Header.Get("Content-Type"); contentType != "" {
-					ext := getContentType(contentType)
+				if resp.ContentType != "" {
+					ext := getContentType(resp.ContentType)
 					if ext != "" {
 						filename += ext
 					}
@@ -432,15 +641,83 @@ func (h *Handler) downloadFiles(urls []string) []DownloadResult {
 			}
 
 			result.Filename = handleFilename(filename)
-			result.Content = content
+			result.ContentType = resp.ContentType
+			result.FinalURL = resp.FinalURL
+			result.Path = path
+			result.Size = size
+			result.SHA256 = sum
 			results[i] = result
-		}(i, urln)
+		}(i, source)
 	}
 
 	wg.Wait()
 	return results
 }
 
+// Сохраняем скачиваемый файл во временный файл, заодно считаем размер и sha256
+func (h *Handler) spool(taskID string, r io.Reader) (string, int64, string, error) {
+	file, err := h.storage.Spool(taskID)
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), r)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", 0, "", err
+	}
+	return file.Name(), size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Удаляем временные файлы скачанных файлов
+func removeSpooled(results []DownloadResult) {
+	for _, result := range results {
+		if result.Path != "" {
+			os.Remove(result.Path)
+		}
+	}
+}
+
+// Разрешён ли тип файла, пустой список разрешает всё
+func (h *Handler) allowedType(contentType string) bool {
+	if len(h.downloads.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, allowed := range h.downloads.AllowedTypes {
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// сообщаем скачалась ли ссылка
+func (h *Handler) publishResult(taskID string, result DownloadResult) {
+	if result.Error != nil {
+		h.events.Publish(Event{TaskID: taskID, Type: EventFailed, URL: result.URL, Error: result.Error.Error()})
+		return
+	}
+	h.events.Publish(Event{TaskID: taskID, Type: EventDone, URL: result.URL, Bytes: result.Size})
+}
+
+// Приоритет из запроса, по умолчанию interactive
+func parsePriority(priority string) (string, bool) {
+	switch priority {
+	case "", PriorityInteractive:
+		return PriorityInteractive, true
+	case PriorityBatch:
+		return PriorityBatch, true
+	default:
+		return "", false
+	}
+}
+
 // Удаляем небезопасные символы
 func handleFilename(filename string) string {
 	filename = strings.ReplaceAll(filename, "/", "_")
@@ -454,6 +731,19 @@ func handleFilename(filename string) string {
 	return filename
 }
 
+// добавляем расширение .zip если его нет
+func zipName(name string) string {
+	if !strings.HasSuffix(name, ".zip") {
+		name += ".zip"
+	}
+	return name
+}
+
+// имя архива не должно выводить за пределы папки с архивами
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name && !strings.ContainsAny(name, "/\\")
+}
+
 // получаем тип файла
 func getContentType(mimeType string) string {
 	switch mimeType {
@@ -465,33 +755,3 @@ func getContentType(mimeType string) string {
 		return ""
 	}
 }
-
-// удаление файлов которые не изменялись более 2 часов
-func FileDeleter() {
-	for true {
-		files, err := os.ReadDir("./")
-		if err != nil {
-			fmt.Printf("Error read dir: %s", err)
-		}
-
-		for _, file := range files {
-			if file.IsDir() || !strings.EqualFold(filepath.Ext(file.Name()), ".zip") {
-				continue
-			}
-
-			filePath := filepath.Join("./", file.Name())
-			fileInfo, err := file.Info()
-			if err != nil {
-				fmt.Printf("Error read Info: %s", err)
-				continue
-			}
-
-			if time.Since(fileInfo.ModTime()) > 2*time.Hour {
-				fmt.Printf("File deletet: %s (no modify %v)\n",
-					file.Name(), time.Since(fileInfo.ModTime()))
-				os.Remove(filePath)
-			}
-		}
-		time.Sleep(time.Minute)
-	}
-}
@@ -0,0 +1,149 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// расшифровываем все файлы архива
+func decryptZip(t *testing.T, reader *zip.Reader, password string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		if f.Flags&0x1 == 0 {
+			t.Fatalf("Entry %s is not encrypted", f.Name)
+		}
+		content, err := internal.DecryptEntry(f, password)
+		if err != nil {
+			t.Fatalf("Decrypt %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestEncryptedDownloadAndZip(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	body := fmt.Sprintf(`{"password": "secret", "urls": ["%s/a.pdf", "%s/b.jpg"]}`, origin.URL, origin.URL)
+	data := downloadZip(t, ts.URL, body)
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := decryptZip(t, reader, "secret")
+	if files["a.pdf"] != "content of /a.pdf" || files["b.jpg"] != "content of /b.jpg" {
+		t.Fatalf("Decrypted files: %v", files)
+	}
+
+	// без пароля файл не открыть
+	if _, err := reader.File[0].Open(); err == nil {
+		t.Fatal("Encrypted entry opened without password")
+	}
+	if _, err := internal.DecryptEntry(reader.File[0], "wrong"); err != internal.ErrWrongPassword {
+		t.Fatalf("Wrong password: %v", err)
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(`{"password": "x", "format": "tar", "urls": ["x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Encrypted tar status: %d", resp.StatusCode)
+	}
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "secret", "password": "secret"}`)
+
+	// без пароля или с другим паролем файлы не добавить
+	for _, password := range []string{"", "other"} {
+		body := fmt.Sprintf(`{"filename": "secret", "password": "%s", "urls": ["%s/a.pdf"]}`, password, origin.URL)
+		if status := post(t, ts.URL+"/add", body); status != http.StatusForbidden {
+			t.Fatalf("Add with password %q status: %d", password, status)
+		}
+	}
+
+	body := fmt.Sprintf(`{"filename": "secret", "password": "secret", "urls": ["%s/a.pdf", "%s/notes.txt"]}`, origin.URL, origin.URL)
+	if task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body)); task.Status != internal.TaskDone || !task.Encrypted {
+		t.Fatalf("Add task: %+v", task)
+	}
+
+	reader, err := zip.OpenReader(filepath.Join(dir, "secret.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	files := decryptZip(t, &reader.Reader, "secret")
+	if files["a.pdf"] != "content of /a.pdf" || files["notes.txt"] != "content of /notes.txt" {
+		t.Fatalf("Decrypted files: %v", files)
+	}
+
+	// файл из архива отдаётся только с паролем
+	get := func(password string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/archives/secret/entries/a.pdf", nil)
+		req.Header.Set(internal.PasswordHeader, password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	if status, content := get("secret"); status != http.StatusOK || content != "content of /a.pdf" {
+		t.Fatalf("Get entry: %d %s", status, content)
+	}
+	if status, _ := get("wrong"); status != http.StatusForbidden {
+		t.Fatalf("Get entry with wrong password: %d", status)
+	}
+
+	// испорченные данные не проходят проверку HMAC и не отдаются
+	entry := reader.File[0]
+	offset, _ := entry.DataOffset()
+	reader.Close()
+	file, err := os.OpenFile(filepath.Join(dir, "secret.zip"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := make([]byte, 1)
+	file.ReadAt(corrupt, offset+20)
+	corrupt[0] ^= 0xff
+	file.WriteAt(corrupt, offset+20)
+	file.Close()
+	if status, _ := get("secret"); status != http.StatusForbidden {
+		t.Fatalf("Get corrupted entry: %d", status)
+	}
+
+	// временные файлы расшифровки удаляются
+	tmp, _ := filepath.Glob(filepath.Join(dir, ".*"))
+	if len(tmp) != 0 {
+		t.Fatalf("Temp files left: %v", tmp)
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentAddToZip(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	if status := post(t, ts.URL+"/create", `{"filename": "shared"}`); status != http.StatusOK {
+		t.Fatalf("Create status: %d", status)
+	}
+
+	// запросы к одному архиву выполняются по очереди и не теряют файлы друг друга
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			body := fmt.Sprintf(`{"filename": "shared", "urls": ["%s/file%d.pdf"]}`, origin.URL, i)
+			if task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body)); task.Status != internal.TaskDone {
+				t.Errorf("Add %d task: %+v", i, task)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	files := readTestZip(t, filepath.Join(dir, "shared.zip"))
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("file%d.pdf", i)
+		if files[name] != "content of /"+name {
+			t.Fatalf("File %s missing: %v", name, files)
+		}
+	}
+
+	// после записи не остаётся временных файлов
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Fatalf("Temp file left: %s", entry.Name())
+		}
+	}
+}
+
+func TestStorageRecover(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+
+	for _, name := range []string{".broken.zip.tmp-123", ".broken.zip.meta.json.tmp-456", "keep.zip", ".hidden"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != ".hidden,keep.zip" {
+		t.Fatalf("Files after recover: %v", names)
+	}
+}
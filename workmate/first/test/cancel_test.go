@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// отменяем задачу и возвращаем код ответа и задачу
+func cancelTask(t *testing.T, url string, method string) (int, internal.Task) {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var task internal.Task
+	json.NewDecoder(resp.Body).Decode(&task)
+	return resp.StatusCode, task
+}
+
+func TestCancelTask(t *testing.T) {
+	// источник "зависает" на /slow до отмены запроса
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.pdf" {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+	queue := internal.NewQueue(1, 10)
+	// один слот на скачивание: если отмена его не освободит, следующая задача не выполнится
+	downloadHandler := internal.NewHandler(queue, internal.NewRateLimiter(1))
+	downloadHandler.SetStorage(storage)
+
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "cancel"}`)
+
+	slow := fmt.Sprintf(`{"filename": "cancel", "urls": ["%s/slow.pdf", "%s/a.pdf", "%s/b.pdf"]}`, origin.URL, origin.URL, origin.URL)
+	running := addTask(t, ts.URL+"/add", slow)
+	time.Sleep(50 * time.Millisecond)
+
+	// вторая задача ждёт в очереди, её отмена завершает её сразу
+	queued := addTask(t, ts.URL+"/add", slow)
+	status, task := cancelTask(t, ts.URL+"/tasks/"+queued+"/cancel", http.MethodPost)
+	if status != http.StatusOK || task.Status != internal.TaskCancelled {
+		t.Fatalf("Queued cancel: %d %+v", status, task)
+	}
+
+	status, task = cancelTask(t, ts.URL+"/tasks/"+running, http.MethodDelete)
+	if status != http.StatusOK || task.Status != internal.TaskCancelled {
+		t.Fatalf("Running cancel: %d %+v", status, task)
+	}
+
+	if status, _ := cancelTask(t, ts.URL+"/tasks/"+running, http.MethodDelete); status != http.StatusConflict {
+		t.Fatalf("Repeated cancel status: %d", status)
+	}
+	if status, _ := cancelTask(t, ts.URL+"/tasks/unknown", http.MethodDelete); status != http.StatusNotFound {
+		t.Fatalf("Unknown cancel status: %d", status)
+	}
+
+	// отменённые задачи ничего не записали в архив
+	if files := readTestZip(t, filepath.Join(dir, "cancel.zip")); len(files) != 0 {
+		t.Fatalf("Files after cancel: %v", files)
+	}
+
+	// слот скачивания освободился, следующая задача выполняется
+	body := fmt.Sprintf(`{"filename": "cancel", "urls": ["%s/c.pdf"]}`, origin.URL)
+	if task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body)); task.Status != internal.TaskDone {
+		t.Fatalf("Task after cancel: %+v", task)
+	}
+}
+
+func TestDownloadAndZipDisconnect(t *testing.T) {
+	// один файл отдаётся сразу, второй не приходит пока клиент не уйдёт
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.pdf" {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	queue := internal.NewQueue(1, 10)
+	downloadHandler := internal.NewHandler(queue, internal.NewRateLimiter(3))
+	downloadHandler.SetStorage(internal.NewStorage(dir, time.Hour))
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	body := fmt.Sprintf(`{"urls": ["%s/a.pdf", "%s/slow.pdf"]}`, origin.URL, origin.URL)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL, strings.NewReader(body))
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("Response before disconnect: %d", resp.StatusCode)
+	}
+
+	// после отмены не остаётся ни скачанных файлов, ни архива
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files, _ := os.ReadDir(dir)
+		if len(files) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Files left after disconnect: %v", files)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
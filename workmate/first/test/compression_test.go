@@ -0,0 +1,98 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// источник: .jpg отдаёт случайные байты (как уже сжатую картинку), остальное - текст
+func newCompressionOrigin(size int) *httptest.Server {
+	random := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := []byte(strings.Repeat("hello compression ", size/18+1)[:size])
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".jpg") {
+			w.Write(random)
+			return
+		}
+		w.Write(text)
+	}))
+}
+
+func TestCompressionPolicy(t *testing.T) {
+	origin := newCompressionOrigin(64 * 1024)
+	defer origin.Close()
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	methods := func(body string) map[string]uint16 {
+		data := downloadZip(t, ts.URL, body)
+		reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]uint16)
+		for _, f := range reader.File {
+			result[f.Name] = f.Method
+		}
+		return result
+	}
+
+	// по умолчанию jpg не сжимается, текст сжимается
+	got := methods(fmt.Sprintf(`{"urls": ["%s/photo.jpg", "%s/notes.txt"]}`, origin.URL, origin.URL))
+	if got["photo.jpg"] != zip.Store || got["notes.txt"] != zip.Deflate {
+		t.Fatalf("Auto methods: %v", got)
+	}
+
+	// политику можно поменять в запросе
+	got = methods(fmt.Sprintf(`{"compression": "store", "urls": ["%s/photo.jpg", "%s/notes.txt"]}`, origin.URL, origin.URL))
+	if got["photo.jpg"] != zip.Store || got["notes.txt"] != zip.Store {
+		t.Fatalf("Store methods: %v", got)
+	}
+	got = methods(fmt.Sprintf(`{"compression": "deflate", "level": 9, "urls": ["%s/photo.jpg"]}`, origin.URL))
+	if got["photo.jpg"] != zip.Deflate {
+		t.Fatalf("Deflate methods: %v", got)
+	}
+
+	for _, body := range []string{`{"compression": "lzma", "urls": ["x"]}`, `{"level": 12, "urls": ["x"]}`} {
+		resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Bad compression %s status: %d", body, resp.StatusCode)
+		}
+	}
+}
+
+// Архив из уже сжатых картинок: deflate против политики auto, которая их не сжимает.
+// go test -bench Compression -benchmem ./test/
+func BenchmarkCompression(b *testing.B) {
+	const size = 4 << 20
+
+	origin := newCompressionOrigin(size)
+	defer origin.Close()
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	for _, mode := range []string{internal.CompressionDeflate, internal.CompressionAuto} {
+		b.Run(mode, func(b *testing.B) {
+			body := fmt.Sprintf(`{"compression": "%s", "urls": ["%s/1.jpg", "%s/2.jpg", "%s/3.jpg"]}`, mode, origin.URL, origin.URL, origin.URL)
+			b.SetBytes(3 * size)
+			for i := 0; i < b.N; i++ {
+				downloadZip(b, ts.URL, body)
+			}
+		})
+	}
+}
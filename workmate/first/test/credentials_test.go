@@ -0,0 +1,162 @@
+package test
+
+import (
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// источник, который отдаёт файл только с правильной авторизацией
+func newAuthOrigin() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		var ok bool
+		switch r.URL.Path {
+		case "/basic.txt", "/userinfo.txt":
+			ok = user == "alice" && password == "basic-secret"
+		case "/bearer.txt":
+			ok = r.Header.Get("Authorization") == "Bearer token-secret"
+		case "/key.txt":
+			ok = r.Header.Get("X-Request-Key") == "key-secret"
+		case "/profile.txt":
+			ok = r.Header.Get("X-Api-Key") == "profile-secret" && user == "bot" && password == "profile-password"
+		}
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+}
+
+func TestSourceCredentials(t *testing.T) {
+	origin := newAuthOrigin()
+	defer origin.Close()
+	// второй хост того же источника, для него в настройках есть профиль
+	profileURL := strings.Replace(origin.URL, "127.0.0.1", "localhost", 1)
+
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "tasks.jsonl")
+	journal, err := internal.OpenJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	downloadHandler, queue := newHandler(internal.NewStorage(dir, time.Hour))
+	queue.SetJournal(journal)
+	downloadHandler.SetDownloads(internal.DownloadsConfig{Profiles: map[string]internal.CredentialProfile{
+		"internal": {
+			Hosts:       []string{"localhost"},
+			Headers:     map[string]string{"X-Api-Key": "profile-secret"},
+			Credentials: internal.Credentials{Username: "bot", Password: "profile-password"},
+		},
+	}})
+
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "secret"}`)
+
+	userinfo := strings.Replace(origin.URL, "http://", "http://alice:basic-secret@", 1) + "/userinfo.txt"
+	body := fmt.Sprintf(`{
+		"filename": "secret",
+		"headers": {"X-Request-Key": "key-secret"},
+		"urls": [
+			{"url": "%[1]s/basic.txt", "credentials": {"username": "alice", "password": "basic-secret"}},
+			{"url": "%[1]s/bearer.txt", "credentials": {"token": "token-secret"}},
+			"%[1]s/key.txt",
+			"%[2]s",
+			"%[3]s/profile.txt"
+		]
+	}`, origin.URL, userinfo, profileURL)
+
+	id := addTask(t, ts.URL+"/add", body)
+	task := waitTask(t, ts.URL, id)
+	if task.Status != internal.TaskDone || len(task.Errors) != 0 || !task.Secrets {
+		t.Fatalf("Task: %+v", task)
+	}
+	if task.URLs[3] != strings.Replace(userinfo, "basic-secret", "xxxxx", 1) {
+		t.Fatalf("Redacted URL: %s", task.URLs[3])
+	}
+
+	files := readTestZip(t, filepath.Join(dir, "secret.zip"))
+	for _, name := range []string{"basic.txt", "bearer.txt", "key.txt", "userinfo.txt", "profile.txt"} {
+		if files[name] != "/"+name {
+			t.Fatalf("Files: %v", files)
+		}
+	}
+
+	// секреты не попадают ни в статус задачи, ни в журнал
+	resp, err := http.Get(ts.URL + "/tasks/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := new(strings.Builder)
+	resp.Write(status)
+	resp.Body.Close()
+	logged, _ := os.ReadFile(journalPath)
+
+	for _, secret := range []string{"basic-secret", "token-secret", "key-secret", "profile-secret", "profile-password"} {
+		if strings.Contains(status.String(), secret) || strings.Contains(string(logged), secret) {
+			t.Fatalf("Secret %s leaked", secret)
+		}
+	}
+}
+
+func TestRedirectDropsHeaders(t *testing.T) {
+	// другой хост запоминает какие заголовки до него дошли
+	var leaked atomic.Value
+	leaked.Store("")
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked.Store(r.Header.Get("X-Api-Key") + r.Header.Get("Authorization"))
+		w.Write([]byte("other host"))
+	}))
+	defer other.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/file.txt", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, other.URL+"/file.txt", http.StatusFound)
+		default:
+			if r.Header.Get("X-Api-Key") != "profile-secret" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("origin"))
+		}
+	}))
+	defer origin.Close()
+
+	cfg := internal.DownloadsConfig{
+		Redirects: internal.RedirectPolicy{MaxHops: 3},
+		Profiles: map[string]internal.CredentialProfile{
+			"internal": {
+				Hosts:       []string{"127.0.0.1"},
+				Headers:     map[string]string{"X-Api-Key": "profile-secret"},
+				Credentials: internal.Credentials{Token: "token-secret"},
+			},
+		},
+	}
+	results := downloadResults(t, cfg, []string{origin.URL + "/same", origin.URL + "/other"})
+
+	// в пределах хоста заголовки остаются, на другой хост не уходят
+	if results[0].Status != internal.ResultOK {
+		t.Fatalf("Same host redirect: %+v", results[0])
+	}
+	if results[1].Status != internal.ResultOK || results[1].FinalURL != other.URL+"/file.txt" {
+		t.Fatalf("Other host redirect: %+v", results[1])
+	}
+	if got := leaked.Load().(string); got != "" {
+		t.Fatalf("Headers leaked to other host: %q", got)
+	}
+}
@@ -0,0 +1,248 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// создаём архив с заданными файлами прямо на диске
+func writeTestZip(t *testing.T, filename string, files map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+	for name, content := range files {
+		writer, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(writer, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Remove(filename) })
+}
+
+func TestListEntries(t *testing.T) {
+	writeTestZip(t, "entries.zip", map[string]string{
+		"a.txt":     "hello",
+		"dir/b.pdf": "pdf content",
+	})
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/archives/entries/entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status: %d", resp.StatusCode)
+	}
+
+	var entries []internal.EntryInfo
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Entries: %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Name == "a.txt" && entry.Size != 5 {
+			t.Fatalf("Size a.txt: %d", entry.Size)
+		}
+		if entry.CRC32 == 0 {
+			t.Fatalf("CRC32 %s is empty", entry.Name)
+		}
+	}
+
+	resp, err = http.Get(ts.URL + "/archives/nosuch/entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Status missing archive: %d", resp.StatusCode)
+	}
+}
+
+func TestGetEntry(t *testing.T) {
+	writeTestZip(t, "entry.zip", map[string]string{
+		"dir/b.txt":                "nested content",
+		"dir/отчёт; \"final\".txt": "report",
+	})
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/archives/entry.zip/entries/dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status: %d", resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "nested content" {
+		t.Fatalf("Body: %s", body)
+	}
+
+	// имя с кавычками, точкой с запятой и не ASCII приходит в заголовке целиком
+	resp, err = http.Get(ts.URL + "/archives/entry/entries/dir/" + url.PathEscape(`отчёт; "final".txt`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] != `отчёт; "final".txt` {
+		t.Fatalf("Content-Disposition: %q %v", resp.Header.Get("Content-Disposition"), err)
+	}
+
+	resp, err = http.Get(ts.URL + "/archives/entry/entries/missing.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Status missing entry: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/archives/..%2Fentry/entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Status bad name: %d", resp.StatusCode)
+	}
+}
+
+// читаем архив с диска и возвращаем содержимое файлов
+func readTestZip(t *testing.T, filename string) map[string]string {
+	t.Helper()
+
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestDeleteEntry(t *testing.T) {
+	writeTestZip(t, "delete.zip", map[string]string{
+		"a.txt":     "first",
+		"dir/b.txt": "second",
+	})
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	req, err := http.NewRequest("DELETE", ts.URL+"/archives/delete/entries/dir/b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Status: %d", resp.StatusCode)
+	}
+
+	files := readTestZip(t, "delete.zip")
+	if len(files) != 1 || files["a.txt"] != "first" {
+		t.Fatalf("Files after delete: %v", files)
+	}
+
+	// повторное удаление того же файла
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Status second delete: %d", resp.StatusCode)
+	}
+}
+
+func TestMoveEntry(t *testing.T) {
+	writeTestZip(t, "move.zip", map[string]string{
+		"a.txt": "first",
+		"b.txt": "second",
+	})
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	move := func(body string) int {
+		resp, err := http.Post(ts.URL+"/archives/move/move", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := move(`{"from": "a.txt", "to": "docs/renamed.txt"}`); status != http.StatusOK {
+		t.Fatalf("Status: %d", status)
+	}
+
+	files := readTestZip(t, "move.zip")
+	if files["docs/renamed.txt"] != "first" || files["b.txt"] != "second" || len(files) != 2 {
+		t.Fatalf("Files after move: %v", files)
+	}
+
+	if status := move(`{"from": "b.txt", "to": "docs/renamed.txt"}`); status != http.StatusConflict {
+		t.Fatalf("Status existing target: %d", status)
+	}
+	if status := move(`{"from": "b.txt", "to": "../escape.txt"}`); status != http.StatusBadRequest {
+		t.Fatalf("Status bad target: %d", status)
+	}
+	if status := move(`{"from": "missing.txt", "to": "c.txt"}`); status != http.StatusNotFound {
+		t.Fatalf("Status missing entry: %d", status)
+	}
+}
@@ -0,0 +1,132 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskEvents(t *testing.T) {
+	// источник ждёт пока клиент подпишется на события и отдаёт 200 КБ
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write(bytes.Repeat([]byte("x"), 200*1024))
+	}))
+	defer origin.Close()
+
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+	ts := newServer(storage)
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "events"}`)
+
+	body := fmt.Sprintf(`{"filename": "events", "urls": ["%s/big.pdf", "bad url"]}`, origin.URL)
+	id := addTask(t, ts.URL+"/add", body)
+
+	resp, err := http.Get(ts.URL + "/tasks/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type: %s", resp.Header.Get("Content-Type"))
+	}
+	close(release)
+
+	// читаем поток до конца, он закрывается после finished
+	var events []internal.Event
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event internal.Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+
+	seen := make(map[string]bool)
+	for _, event := range events {
+		seen[event.Type+" "+event.URL] = true
+	}
+
+	big := origin.URL + "/big.pdf"
+	for _, want := range []string{
+		internal.EventStarted + " " + big,
+		internal.EventProgress + " " + big,
+		internal.EventDone + " " + big,
+		internal.EventFailed + " bad url",
+		internal.EventArchive + " ",
+	} {
+		if !seen[want] {
+			t.Fatalf("Event %q missing in %+v", want, events)
+		}
+	}
+
+	last := events[len(events)-1]
+	if last.Type != internal.EventFinished || last.Status != internal.TaskDone {
+		t.Fatalf("Last event: %+v", last)
+	}
+
+	// после завершения поток сразу отдаёт историю и закрывается
+	resp, err = http.Get(ts.URL + "/tasks/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	scanner = bufio.NewScanner(resp.Body)
+	var finished bool
+	for scanner.Scan() {
+		if scanner.Text() == "event: "+internal.EventFinished {
+			finished = true
+		}
+	}
+	if !finished {
+		t.Fatal("Finished event missing after task completion")
+	}
+}
+
+func TestBusHistoryTTL(t *testing.T) {
+	bus := internal.NewBus()
+	bus.SetHistoryTTL(50 * time.Millisecond)
+
+	bus.Publish(internal.Event{TaskID: "task", Type: internal.EventStarted, URL: "http://example.com/a"})
+	bus.Publish(internal.Event{TaskID: "task", Type: internal.EventFinished, Status: internal.TaskDone})
+
+	// сразу после завершения история ещё есть
+	history, events, cancel := bus.Subscribe("task")
+	cancel()
+	if len(history) != 2 {
+		t.Fatalf("History: %+v", history)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("Events channel of finished task is open")
+	}
+
+	// потом она удаляется
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		history, _, cancel := bus.Subscribe("task")
+		cancel()
+		if len(history) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("History kept: %+v", history)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,283 @@
+package test
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Простой ftp сервер в процессе: логин, TYPE, SIZE, EPSV и RETR
+func newFTPServer(t *testing.T, files map[string]string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFTP(conn, files)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveFTP(conn net.Conn, files map[string]string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var data net.Listener
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+
+	reply("220 test ftp")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+		switch cmd {
+		case "USER":
+			reply("331 password please")
+		case "PASS":
+			if arg != "secret" {
+				reply("530 login incorrect")
+				continue
+			}
+			reply("230 logged in")
+		case "TYPE":
+			reply("200 binary")
+		case "SIZE":
+			content, ok := files[arg]
+			if !ok {
+				reply("550 no such file")
+				continue
+			}
+			reply("213 %d", len(content))
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "RETR":
+			content, ok := files[arg]
+			if !ok {
+				reply("550 no such file")
+				continue
+			}
+			reply("150 sending")
+			dataConn, err := data.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(dataConn, content)
+			dataConn.Close()
+			reply("226 done")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// Поддельный S3: проверяет подпись Signature V4 и отдаёт объекты по пути /bucket/key
+func newFakeS3(objects map[string]string, accessKey, secretKey string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validSigV4(r, accessKey, secretKey) {
+			http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+			return
+		}
+		content, ok := objects[r.URL.EscapedPath()]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(w, content)
+	}))
+}
+
+// Считаем подпись запроса заново и сравниваем с Authorization
+func validSigV4(r *http.Request, accessKey, secretKey string) bool {
+	amzDate := r.Header.Get("X-Amz-Date")
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if len(amzDate) != 16 || payload == "" {
+		return false
+	}
+	date := amzDate[:8]
+
+	sign := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	hash := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+
+	canonical := strings.Join([]string{
+		r.Method, r.URL.EscapedPath(), r.URL.RawQuery,
+		"host:" + r.Host, "x-amz-content-sha256:" + payload, "x-amz-date:" + amzDate, "",
+		"host;x-amz-content-sha256;x-amz-date", payload,
+	}, "\n")
+	scope := date + "/us-east-1/s3/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hash(canonical)}, "\n")
+
+	key := sign([]byte("AWS4"+secretKey), date)
+	key = sign(key, "us-east-1")
+	key = sign(key, "s3")
+	key = sign(key, "aws4_request")
+
+	want := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		accessKey, scope, hex.EncodeToString(sign(key, toSign)))
+	return hmac.Equal([]byte(r.Header.Get("Authorization")), []byte(want))
+}
+
+func TestFetcherSchemes(t *testing.T) {
+	ftpAddr := newFTPServer(t, map[string]string{"/pub/report.txt": "from ftp"})
+	s3 := newFakeS3(map[string]string{"/assets/img/logo%20v2.png": "from s3"}, "AKIDTEST", "s3secret")
+	defer s3.Close()
+
+	downloadHandler, _ := newHandler(internal.NewStorage(t.TempDir(), time.Hour))
+	downloadHandler.SetS3(internal.S3Config{
+		Endpoint:  s3.URL,
+		AccessKey: "AKIDTEST",
+		SecretKey: "s3secret",
+		PathStyle: true,
+	})
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	urls := []string{
+		"data:text/plain;base64,ZnJvbSBkYXRhIHVyaQ==",
+		"ftp://user:secret@" + ftpAddr + "/pub/report.txt",
+		"s3://assets/img/logo v2.png",
+		"ftp://user:secret@" + ftpAddr + "/pub/missing.txt",
+		"s3://assets/missing.png",
+		"gopher://example.com/file",
+	}
+	encoded, _ := json.Marshal(urls)
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(fmt.Sprintf(`{"urls": %s}`, encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status: %d %s", resp.StatusCode, data)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readZipFiles(t, reader, "file_0", "report.txt", "logo v2.png")
+	if files["file_0"] != "from data uri" || files["report.txt"] != "from ftp" || files["logo v2.png"] != "from s3" {
+		t.Fatalf("Files: %v", files)
+	}
+
+	var doc internal.ResultDocument
+	if err := json.Unmarshal([]byte(resp.Trailer.Get(internal.ResultsTrailer)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	wantClasses := []string{"", "", "", internal.ErrorClassFTPStatus, internal.ErrorClassHTTPStatus, internal.ErrorClassScheme}
+	for i, result := range doc.Results {
+		if result.ErrorClass != wantClasses[i] {
+			t.Fatalf("Result %d: %+v", i, result)
+		}
+	}
+	if doc.Results[4].HTTPStatus != http.StatusNotFound {
+		t.Fatalf("S3 missing object: %+v", doc.Results[4])
+	}
+}
+
+func TestS3WrongSecret(t *testing.T) {
+	s3 := newFakeS3(map[string]string{"/assets/a.txt": "a"}, "AKIDTEST", "s3secret")
+	defer s3.Close()
+
+	downloadHandler, _ := newHandler(internal.NewStorage(t.TempDir(), time.Hour))
+	downloadHandler.SetS3(internal.S3Config{
+		Endpoint:  s3.URL,
+		AccessKey: "AKIDTEST",
+		SecretKey: "wrong",
+		PathStyle: true,
+	})
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(`{"urls": ["s3://assets/a.txt"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var doc internal.ResultDocument
+	json.NewDecoder(resp.Body).Decode(&doc)
+	if resp.StatusCode != http.StatusPartialContent || len(doc.Results) != 1 || doc.Results[0].HTTPStatus != http.StatusForbidden {
+		t.Fatalf("Status: %d %+v", resp.StatusCode, doc)
+	}
+}
+
+func TestFTPCommandInjection(t *testing.T) {
+	// сервер только считает подключения: до него не должна дойти ни одна ссылка
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			conn.Close()
+		}
+	}()
+	addr := listener.Addr().String()
+
+	results := downloadResults(t, internal.DownloadsConfig{}, []string{
+		"ftp://" + addr + "/a%0d%0aDELE%20secret.txt",
+		"ftp://" + addr + "/a%00.txt",
+		"ftp://us%0d%0aer:secret@" + addr + "/a.txt",
+		"ftp://user:se%0acret@" + addr + "/a.txt",
+	})
+	for i, result := range results {
+		if result.ErrorClass != internal.ErrorClassInvalidURL {
+			t.Fatalf("Result %d: %+v", i, result)
+		}
+	}
+	if n := conns.Load(); n != 0 {
+		t.Fatalf("Connections to ftp server: %d", n)
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestArchiveFormats(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		format      string
+		contentType string
+		disposition string
+	}{
+		{internal.FormatTar, "application/x-tar", "attachment; filename=files.tar"},
+		{internal.FormatTarGz, "application/gzip", "attachment; filename=files.tar.gz"},
+	} {
+		body := fmt.Sprintf(`{"filename": "files", "format": "%s", "urls": ["%s/a.pdf", "%s/b.jpg"]}`, tc.format, origin.URL, origin.URL)
+		resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != tc.contentType {
+			t.Fatalf("%s: status %d, Content-Type %s", tc.format, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if disposition := resp.Header.Get("Content-Disposition"); disposition != tc.disposition {
+			t.Fatalf("%s: Content-Disposition %s", tc.format, disposition)
+		}
+
+		var reader io.Reader = bytes.NewReader(data)
+		if tc.format == internal.FormatTarGz {
+			if reader, err = gzip.NewReader(reader); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		files := make(map[string]string)
+		archive := tar.NewReader(reader)
+		for {
+			header, err := archive.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tc.format, err)
+			}
+			content, _ := io.ReadAll(archive)
+			files[header.Name] = string(content)
+		}
+
+		if files["a.pdf"] != "content of /a.pdf" || files["b.jpg"] != "content of /b.jpg" {
+			t.Fatalf("%s: files %v", tc.format, files)
+		}
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(`{"format": "rar", "urls": ["x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Unknown format status: %d", resp.StatusCode)
+	}
+}
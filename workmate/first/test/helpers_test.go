@@ -0,0 +1,135 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// обработчик с настройками по умолчанию для тестов: 3 воркера, очередь на 10 задач, 3 загрузки.
+// storage nil - хранилище по умолчанию
+func newHandler(storage *internal.Storage) (*internal.Handler, *internal.Queue) {
+	queue := internal.NewQueue(3, 10)
+	downloadHandler := internal.NewHandler(queue, internal.NewRateLimiter(3))
+	if storage != nil {
+		downloadHandler.SetStorage(storage)
+	}
+	return downloadHandler, queue
+}
+
+// роутер со всеми ручками сервиса. Тест, которому нужна своя ручка, регистрирует её
+// в своём роутере, а остальное отдаёт сюда через router.Handle("/", newRouter(h))
+func newRouter(downloadHandler *internal.Handler) *http.ServeMux {
+	router := http.NewServeMux()
+	router.HandleFunc("/{$}", downloadHandler.DownloadAndZip)
+	router.HandleFunc("/create", downloadHandler.CreateZip)
+	router.HandleFunc("/add", downloadHandler.AddToZip)
+	router.HandleFunc("/download", downloadHandler.DownloadZip)
+	router.HandleFunc("/downloadanddelete", downloadHandler.DownloadZipAndDelete)
+	router.HandleFunc("GET /archives/{name}", downloadHandler.GetArchive)
+	router.HandleFunc("GET /archives/{name}/entries", downloadHandler.ListEntries)
+	router.HandleFunc("GET /archives/{name}/entries/{path...}", downloadHandler.GetEntry)
+	router.HandleFunc("DELETE /archives/{name}/entries/{path...}", downloadHandler.DeleteEntry)
+	router.HandleFunc("POST /archives/{name}/move", downloadHandler.MoveEntry)
+	router.HandleFunc("POST /archives/{name}/pin", downloadHandler.PinArchive)
+	router.HandleFunc("DELETE /archives/{name}/pin", downloadHandler.UnpinArchive)
+	router.HandleFunc("GET /usage", downloadHandler.GetUsage)
+	router.HandleFunc("GET /tasks/{id}", downloadHandler.TaskStatus)
+	router.HandleFunc("GET /tasks/{id}/results", downloadHandler.TaskResults)
+	router.HandleFunc("GET /tasks/{id}/deliveries", downloadHandler.TaskDeliveries)
+	router.HandleFunc("GET /tasks/{id}/events", downloadHandler.TaskEvents)
+	router.HandleFunc("DELETE /tasks/{id}", downloadHandler.CancelTask)
+	router.HandleFunc("POST /tasks/{id}/cancel", downloadHandler.CancelTask)
+	return router
+}
+
+// сервер с настройками по умолчанию
+func newServer(storage *internal.Storage) *httptest.Server {
+	downloadHandler, _ := newHandler(storage)
+	return httptest.NewServer(newRouter(downloadHandler))
+}
+
+// источник файлов для тестов: отдаёт имя файла как содержимое
+func newOrigin() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+}
+
+func post(t *testing.T, url string, body string) int {
+	t.Helper()
+
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// ставим задачу на добавление файлов и возвращаем её id
+func addTask(t *testing.T, url string, body string) string {
+	t.Helper()
+
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Add status: %d", resp.StatusCode)
+		return ""
+	}
+
+	var accepted struct {
+		TaskID string `json:"task_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&accepted)
+	return accepted.TaskID
+}
+
+// ждём пока задача завершится
+func waitTask(t *testing.T, baseURL string, id string) internal.Task {
+	t.Helper()
+
+	var task internal.Task
+	for i := 0; i < 200; i++ {
+		resp, err := http.Get(baseURL + "/tasks/" + id)
+		if err != nil {
+			t.Error(err)
+			return task
+		}
+		json.NewDecoder(resp.Body).Decode(&task)
+		resp.Body.Close()
+
+		if task.Finished() {
+			return task
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Task %s not finished: %+v", id, task)
+	return task
+}
+
+// собираем архив через /downloadandzip
+func downloadZip(tb testing.TB, url string, body string) []byte {
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		tb.Fatalf("Status: %d %s", resp.StatusCode, data)
+	}
+	return data
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// запрос с ключом идемпотентности, возвращаем ответ целиком
+func postWithKey(t *testing.T, url string, key string, body string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(internal.IdempotencyHeader, key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	return resp, data
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	downloadHandler, _ := newHandler(storage)
+	idempotency := internal.NewIdempotency(time.Hour)
+
+	router := http.NewServeMux()
+	router.HandleFunc("/create", idempotency.Wrap(downloadHandler.CreateZip))
+	router.HandleFunc("/add", idempotency.Wrap(downloadHandler.AddToZip))
+	router.Handle("/", newRouter(downloadHandler))
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// повтор создания архива получает тот же ответ, а не ошибку имени
+	first, firstBody := postWithKey(t, ts.URL+"/create", "create-1", `{"filename": "idem"}`)
+	again, againBody := postWithKey(t, ts.URL+"/create", "create-1", `{"filename": "idem"}`)
+	if first.StatusCode != http.StatusOK || again.StatusCode != http.StatusOK || !bytes.Equal(firstBody, againBody) {
+		t.Fatalf("Create replay: %d %s / %d %s", first.StatusCode, firstBody, again.StatusCode, againBody)
+	}
+	if again.Header.Get(internal.ReplayedHeader) != "true" || first.Header.Get(internal.ReplayedHeader) != "" {
+		t.Fatal("Replayed header missing")
+	}
+
+	// тот же ключ с другим телом отклоняется
+	if resp, _ := postWithKey(t, ts.URL+"/create", "create-1", `{"filename": "other"}`); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("Key reuse status: %d", resp.StatusCode)
+	}
+
+	// повтор добавления возвращает ту же задачу и не дублирует файлы
+	body := fmt.Sprintf(`{"filename": "idem", "urls": ["%s/a.pdf"]}`, origin.URL)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		resp, data := postWithKey(t, ts.URL+"/add", "add-1", body)
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Add status: %d", resp.StatusCode)
+		}
+		var accepted struct {
+			TaskID string `json:"task_id"`
+		}
+		json.Unmarshal(data, &accepted)
+		ids = append(ids, accepted.TaskID)
+	}
+	if ids[0] == "" || ids[0] != ids[1] {
+		t.Fatalf("Task ids: %v", ids)
+	}
+	waitTask(t, ts.URL, ids[0])
+
+	files := readTestZip(t, filepath.Join(dir, "idem.zip"))
+	if len(files) != 1 {
+		t.Fatalf("Files after retry: %v", files)
+	}
+}
+
+func TestIdempotencyWrongPassword(t *testing.T) {
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	downloadHandler, _ := newHandler(storage)
+	idempotency := internal.NewIdempotency(time.Hour)
+
+	router := http.NewServeMux()
+	router.HandleFunc("DELETE /archives/{name}/entries/{path...}", idempotency.Wrap(downloadHandler.DeleteEntry))
+	router.Handle("/", newRouter(downloadHandler))
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "locked", "password": "secret"}`)
+	body := fmt.Sprintf(`{"filename": "locked", "password": "secret", "urls": ["%s/a.pdf"]}`, origin.URL)
+	if task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body)); task.Status != internal.TaskDone {
+		t.Fatalf("Add task: %+v", task)
+	}
+
+	remove := func(password string) *http.Response {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/archives/locked/entries/a.pdf", nil)
+		req.Header.Set(internal.IdempotencyHeader, "delete-1")
+		req.Header.Set(internal.PasswordHeader, password)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// отказ из-за пароля не запоминается, повтор с тем же ключом и верным паролем выполняется
+	if resp := remove("wrong"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Wrong password status: %d", resp.StatusCode)
+	}
+	resp := remove("secret")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(internal.ReplayedHeader) != "" {
+		t.Fatalf("Right password status: %d replayed %q", resp.StatusCode, resp.Header.Get(internal.ReplayedHeader))
+	}
+}
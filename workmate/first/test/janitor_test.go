@@ -0,0 +1,200 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJanitorSweep(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, 2*time.Hour)
+
+	downloadHandler, _ := newHandler(storage)
+	janitor := internal.NewJanitor(storage, time.Minute, "secret")
+
+	router := http.NewServeMux()
+	router.HandleFunc("/admin/sweep", janitor.HandleSweep)
+	router.Handle("/", newRouter(downloadHandler))
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, body := range []string{
+		`{"filename": "short", "ttl": "1ms"}`,
+		`{"filename": "pinned", "ttl": "1ms"}`,
+		`{"filename": "long"}`,
+	} {
+		resp, err := http.Post(ts.URL+"/create", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Create %s status: %d", body, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/archives/pinned/pin", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// чужой zip без метаданных уборщик трогать не должен
+	foreign := filepath.Join(dir, "foreign.zip")
+	if err := os.WriteFile(foreign, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	os.Chtimes(foreign, old, old)
+
+	time.Sleep(10 * time.Millisecond)
+
+	sweep := func(method string, token string) (int, internal.SweepReport) {
+		req, err := http.NewRequest(method, ts.URL+"/admin/sweep", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var report internal.SweepReport
+		json.NewDecoder(resp.Body).Decode(&report)
+		return resp.StatusCode, report
+	}
+
+	if status, _ := sweep("POST", "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("Status wrong token: %d", status)
+	}
+
+	// dry-run ничего не удаляет
+	status, report := sweep("GET", "secret")
+	if status != http.StatusOK || !report.DryRun {
+		t.Fatalf("Dry run status: %d %+v", status, report)
+	}
+
+	actions := make(map[string]string)
+	for _, item := range report.Items {
+		actions[item.FileName] = item.Action
+	}
+	if actions["short.zip"] != internal.SweepDelete || actions["pinned.zip"] != internal.SweepPinned ||
+		actions["long.zip"] != internal.SweepKeep || len(actions) != 3 {
+		t.Fatalf("Dry run actions: %v", actions)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "short.zip")); err != nil {
+		t.Fatalf("Dry run removed file: %v", err)
+	}
+
+	sweep("POST", "secret")
+
+	if _, err := os.Stat(filepath.Join(dir, "short.zip")); !os.IsNotExist(err) {
+		t.Fatalf("Expired archive not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "short.zip.meta.json")); !os.IsNotExist(err) {
+		t.Fatalf("Expired meta not removed: %v", err)
+	}
+	for _, name := range []string{"pinned.zip", "long.zip", "foreign.zip"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Archive %s removed: %v", name, err)
+		}
+	}
+}
+
+// чтение архива обновляет метаданные и не должно затирать закрепление
+func TestPinConcurrentReads(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, 2*time.Hour)
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "busy"}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := http.Get(ts.URL + "/archives/busy")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	status := post(t, ts.URL+"/archives/busy/pin", "")
+	wg.Wait()
+
+	meta, err := storage.ReadMeta("busy.zip")
+	if status != http.StatusOK || err != nil || !meta.Pinned {
+		t.Fatalf("Pin lost: status %d, meta %+v, %v", status, meta, err)
+	}
+}
+
+func TestReadWhileArchiveLocked(t *testing.T) {
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "locked"}`)
+
+	// архив дописывается, чтение его не ждёт
+	unlock := storage.Lock("locked.zip")
+	defer unlock()
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	for _, path := range []string{"/archives/locked", "/archives/locked/entries"} {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s status: %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestJanitorSweepWithoutToken(t *testing.T) {
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+	janitor := internal.NewJanitor(storage, time.Minute, "")
+
+	ts := httptest.NewServer(http.HandlerFunc(janitor.HandleSweep))
+	defer ts.Close()
+
+	// без admin_token уборку не запустить даже с пустым токеном в заголовке
+	for _, auth := range []string{"", "Bearer "} {
+		req, err := http.NewRequest("POST", ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Status %q: %d", auth, resp.StatusCode)
+		}
+	}
+}
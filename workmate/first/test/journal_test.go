@@ -0,0 +1,133 @@
+package test
+
+import (
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// поднимаем сервер с журналом, как после запуска
+func newJournalServer(t *testing.T, storage *internal.Storage, path string) (*httptest.Server, *internal.Journal) {
+	t.Helper()
+
+	journal, err := internal.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := journal.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloadHandler, queue := newHandler(storage)
+	queue.SetJournal(journal)
+	queue.Restore(tasks)
+
+	return httptest.NewServer(newRouter(downloadHandler)), journal
+}
+
+func TestJournalRestore(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+	path := filepath.Join(dir, "tasks.jsonl")
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	// первый запуск: одна задача успевает выполниться
+	ts, journal := newJournalServer(t, storage, path)
+	post(t, ts.URL+"/create", `{"filename": "journal"}`)
+
+	body := fmt.Sprintf(`{"filename": "journal", "urls": ["%s/first.pdf", "bad url"]}`, origin.URL)
+	doneID := addTask(t, ts.URL+"/add", body)
+	if task := waitTask(t, ts.URL, doneID); task.Status != internal.TaskDone {
+		t.Fatalf("First task: %+v", task)
+	}
+	ts.Close()
+
+	// вторая задача попала в журнал, но сервер упал до её выполнения
+	pending := &internal.Task{
+		ID:       "pending-task",
+		Kind:     internal.TaskAdd,
+		Status:   internal.TaskQueued,
+		FileName: "journal.zip",
+		URLs:     []string{origin.URL + "/second.pdf"},
+	}
+	journal.Append(internal.JournalEntry{Type: internal.JournalCreated, TaskID: pending.ID, Task: pending})
+	journal.Close()
+
+	// второй запуск
+	ts, journal = newJournalServer(t, storage, path)
+	defer ts.Close()
+	defer journal.Close()
+
+	task := waitTask(t, ts.URL, doneID)
+	if task.Status != internal.TaskDone || len(task.Errors) != 1 || task.Errors[0].URL != "bad url" {
+		t.Fatalf("Restored done task: %+v", task)
+	}
+
+	if task := waitTask(t, ts.URL, pending.ID); task.Status != internal.TaskDone {
+		t.Fatalf("Resumed task: %+v", task)
+	}
+
+	files := readTestZip(t, filepath.Join(dir, "journal.zip"))
+	if files["first.pdf"] == "" || files["second.pdf"] == "" {
+		t.Fatalf("Files after restore: %v", files)
+	}
+}
+
+func TestQueuePrune(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+	path := filepath.Join(dir, "tasks.jsonl")
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	journal, err := internal.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	downloadHandler, queue := newHandler(storage)
+	queue.SetJournal(journal)
+	queue.SetRetention(300 * time.Millisecond)
+
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "prune"}`)
+	body := fmt.Sprintf(`{"filename": "prune", "urls": ["%s/a.pdf"]}`, origin.URL)
+	oldID := addTask(t, ts.URL+"/add", body)
+	waitTask(t, ts.URL, oldID)
+
+	// свежая задача ещё не устарела
+	time.Sleep(400 * time.Millisecond)
+	newID := addTask(t, ts.URL+"/add", body)
+	waitTask(t, ts.URL, newID)
+
+	if n := queue.Prune(); n != 1 {
+		t.Fatalf("Pruned: %d", n)
+	}
+	if _, ok := queue.Get(oldID); ok {
+		t.Fatal("Old task is still known")
+	}
+	if _, ok := queue.Get(newID); !ok {
+		t.Fatal("New task pruned")
+	}
+
+	// в сжатом журнале только оставшаяся задача, новые записи дописываются после неё
+	id := addTask(t, ts.URL+"/add", body)
+	waitTask(t, ts.URL, id)
+	tasks, err := journal.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].ID != newID || tasks[1].ID != id || tasks[1].Status != internal.TaskDone {
+		t.Fatalf("Journal after prune: %+v", tasks)
+	}
+}
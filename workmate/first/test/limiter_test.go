@@ -0,0 +1,160 @@
+package test
+
+import (
+	"context"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"testing"
+	"time"
+)
+
+// занимаем слот в фоне, канал закрывается когда слот получен
+func acquireAsync(limiter *internal.RateLimiter, priority string) chan struct{} {
+	acquired := make(chan struct{})
+	go func() {
+		limiter.AcquirePriority(context.Background(), priority)
+		close(acquired)
+	}()
+	return acquired
+}
+
+func isAcquired(acquired chan struct{}, wait time.Duration) bool {
+	select {
+	case <-acquired:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+func TestLimiterReserved(t *testing.T) {
+	limiter := internal.NewRateLimiter(2)
+	limiter.SetPriorities(1, 0)
+
+	// batch не может занять зарезервированный слот
+	if !isAcquired(acquireAsync(limiter, internal.PriorityBatch), time.Second) {
+		t.Fatal("First batch not acquired")
+	}
+	batch := acquireAsync(limiter, internal.PriorityBatch)
+	if isAcquired(batch, 50*time.Millisecond) {
+		t.Fatal("Batch took reserved slot")
+	}
+
+	// interactive проходит сразу
+	if !isAcquired(acquireAsync(limiter, internal.PriorityInteractive), time.Second) {
+		t.Fatal("Interactive not acquired")
+	}
+
+	// освободившийся слот получает interactive, хотя batch ждёт дольше
+	interactive := acquireAsync(limiter, internal.PriorityInteractive)
+	time.Sleep(20 * time.Millisecond)
+	limiter.Release()
+	if !isAcquired(interactive, time.Second) {
+		t.Fatal("Waiting interactive not acquired")
+	}
+	if isAcquired(batch, 50*time.Millisecond) {
+		t.Fatal("Batch acquired before interactive")
+	}
+
+	// отменённое ожидание не занимает слот
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.AcquirePriority(ctx, internal.PriorityInteractive); err != context.Canceled {
+		t.Fatalf("Cancelled acquire: %v", err)
+	}
+}
+
+func TestLimiterAging(t *testing.T) {
+	limiter := internal.NewRateLimiter(2)
+	limiter.SetPriorities(1, 100*time.Millisecond)
+
+	limiter.AcquirePriority(context.Background(), internal.PriorityBatch)
+
+	// зарезервированный слот свободен, но batch получает его только после aging
+	start := time.Now()
+	batch := acquireAsync(limiter, internal.PriorityBatch)
+	if !isAcquired(batch, time.Second) {
+		t.Fatal("Aged batch not acquired")
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Fatalf("Batch acquired after %v", waited)
+	}
+}
+
+// занимаем слот для хоста в фоне
+func acquireHostAsync(limiter *internal.RateLimiter, host string) chan struct{} {
+	acquired := make(chan struct{})
+	go func() {
+		limiter.AcquireHost(context.Background(), internal.PriorityInteractive, host)
+		close(acquired)
+	}()
+	return acquired
+}
+
+func TestLimiterPerHost(t *testing.T) {
+	limiter := internal.NewRateLimiter(3)
+	limiter.SetHosts(1, 0, map[string]internal.HostLimit{"example.com": {PerHost: 2}})
+
+	// у обычного хоста один запрос, остальные слоты достаются другим хостам
+	if !isAcquired(acquireHostAsync(limiter, "a.org"), time.Second) {
+		t.Fatal("First a.org not acquired")
+	}
+	second := acquireHostAsync(limiter, "a.org")
+	if isAcquired(second, 50*time.Millisecond) {
+		t.Fatal("Second a.org acquired over per host limit")
+	}
+
+	// у поддоменов example.com своё ограничение - два запроса
+	if !isAcquired(acquireHostAsync(limiter, "cdn.example.com"), time.Second) {
+		t.Fatal("First cdn.example.com not acquired")
+	}
+	if !isAcquired(acquireHostAsync(limiter, "cdn.example.com"), time.Second) {
+		t.Fatal("Second cdn.example.com not acquired")
+	}
+
+	limiter.ReleaseHost("a.org")
+	if !isAcquired(second, time.Second) {
+		t.Fatal("Second a.org not acquired after release")
+	}
+}
+
+func TestLimiterFairHosts(t *testing.T) {
+	limiter := internal.NewRateLimiter(2)
+
+	// большая задача заняла оба слота одним хостом и ждёт ещё
+	limiter.AcquireHost(context.Background(), internal.PriorityInteractive, "big.org")
+	limiter.AcquireHost(context.Background(), internal.PriorityInteractive, "big.org")
+	big := acquireHostAsync(limiter, "big.org")
+	time.Sleep(20 * time.Millisecond)
+	small := acquireHostAsync(limiter, "small.org")
+	time.Sleep(20 * time.Millisecond)
+
+	// освободившийся слот получает хост без запросов, хотя big.org ждёт дольше
+	limiter.ReleaseHost("big.org")
+	if !isAcquired(small, time.Second) {
+		t.Fatal("small.org not acquired")
+	}
+	if isAcquired(big, 50*time.Millisecond) {
+		t.Fatal("big.org acquired before small.org")
+	}
+}
+
+func TestLimiterHostDelay(t *testing.T) {
+	limiter := internal.NewRateLimiter(3)
+	limiter.SetHosts(0, 100*time.Millisecond, nil)
+
+	limiter.AcquireHost(context.Background(), internal.PriorityInteractive, "a.org")
+	limiter.ReleaseHost("a.org")
+
+	// следующий запрос к тому же хосту ждёт паузу, к другому - нет
+	start := time.Now()
+	again := acquireHostAsync(limiter, "a.org")
+	if !isAcquired(acquireHostAsync(limiter, "b.org"), 50*time.Millisecond) {
+		t.Fatal("b.org waited for a.org delay")
+	}
+	if !isAcquired(again, time.Second) {
+		t.Fatal("a.org not acquired after delay")
+	}
+	if waited := time.Since(start); waited < 90*time.Millisecond {
+		t.Fatalf("a.org acquired after %v", waited)
+	}
+}
@@ -0,0 +1,196 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestManifestDownloadAndZip(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(nil)
+	defer ts.Close()
+
+	body := fmt.Sprintf(`{"manifest": true, "urls": ["%s/a.pdf", "bad url"]}`, origin.URL)
+	data := downloadZip(t, ts.URL, body)
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		rc, _ := f.Open()
+		var buf bytes.Buffer
+		buf.ReadFrom(rc)
+		rc.Close()
+		files[f.Name] = buf.String()
+	}
+
+	var manifest internal.Manifest
+	if err := json.Unmarshal([]byte(files[internal.ManifestName]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("Manifest: %+v", manifest)
+	}
+
+	ok, failed := manifest.Files[0], manifest.Files[1]
+	if ok.URL != origin.URL+"/a.pdf" || ok.Name != "a.pdf" || ok.Size != int64(len("content of /a.pdf")) || ok.SHA256 != sha256Hex("content of /a.pdf") {
+		t.Fatalf("Manifest entry: %+v", ok)
+	}
+	if failed.URL != "bad url" || failed.Error == "" || failed.Name != "" {
+		t.Fatalf("Failed manifest entry: %+v", failed)
+	}
+
+	if want := sha256Hex("content of /a.pdf") + "  a.pdf\n"; files[internal.ChecksumsName] != want {
+		t.Fatalf("SHA256SUMS: %q", files[internal.ChecksumsName])
+	}
+}
+
+func TestManifestAddToZip(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	if status := post(t, ts.URL+"/create", `{"filename": "manifest", "manifest": true}`); status != http.StatusOK {
+		t.Fatalf("Create status: %d", status)
+	}
+
+	// манифест обновляется при каждом добавлении,
+	// скачанный manifest.json не путается с манифестом и переименовывается
+	for _, name := range []string{"a.pdf", internal.ManifestName, "b.pdf"} {
+		body := fmt.Sprintf(`{"filename": "manifest", "urls": ["%s/%s"]}`, origin.URL, name)
+		if task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body)); task.Status != internal.TaskDone {
+			t.Fatalf("Add task: %+v", task)
+		}
+	}
+
+	reader, err := zip.OpenReader(filepath.Join(dir, "manifest.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var count int
+	for _, f := range reader.File {
+		if f.Name == internal.ManifestName {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("Manifest copies: %d", count)
+	}
+
+	files := readTestZip(t, filepath.Join(dir, "manifest.zip"))
+	var manifest internal.Manifest
+	if err := json.Unmarshal([]byte(files[internal.ManifestName]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 3 || manifest.Files[0].Name != "a.pdf" || manifest.Files[1].Name != "manifest_1.json" || manifest.Files[2].Name != "b.pdf" {
+		t.Fatalf("Manifest: %+v", manifest)
+	}
+	if files["manifest_1.json"] != "content of /manifest.json" {
+		t.Fatalf("Downloaded manifest.json: %q", files["manifest_1.json"])
+	}
+
+	want := sha256Hex("content of /a.pdf") + "  a.pdf\n" + sha256Hex("content of /manifest.json") + "  manifest_1.json\n" +
+		sha256Hex("content of /b.pdf") + "  b.pdf\n"
+	if files[internal.ChecksumsName] != want {
+		t.Fatalf("SHA256SUMS: %q", files[internal.ChecksumsName])
+	}
+}
+
+func TestManifestEntryChanges(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	change := func(method, url, body, password string) int {
+		req, _ := http.NewRequest(method, ts.URL+url, bytes.NewBufferString(body))
+		if password != "" {
+			req.Header.Set(internal.PasswordHeader, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, password := range []string{"", "secret"} {
+		name := "changes" + password
+		post(t, ts.URL+"/create", fmt.Sprintf(`{"filename": "%s", "manifest": true, "password": "%s"}`, name, password))
+		body := fmt.Sprintf(`{"filename": "%s", "password": "%s", "urls": ["%s/a.pdf", "%s/b.pdf", "%s/c.pdf"]}`,
+			name, password, origin.URL, origin.URL, origin.URL)
+		if task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body)); task.Status != internal.TaskDone {
+			t.Fatalf("Add task: %+v", task)
+		}
+
+		if password != "" {
+			// зашифрованный манифест без пароля не пересобрать
+			if status := change("DELETE", "/archives/"+name+"/entries/a.pdf", "", ""); status != http.StatusForbidden {
+				t.Fatalf("Delete without password: %d", status)
+			}
+		}
+		if status := change("DELETE", "/archives/"+name+"/entries/a.pdf", "", password); status != http.StatusNoContent {
+			t.Fatalf("Delete status: %d", status)
+		}
+		if status := change("POST", "/archives/"+name+"/move", `{"from": "b.pdf", "to": "docs/b.pdf"}`, password); status != http.StatusOK {
+			t.Fatalf("Move status: %d", status)
+		}
+
+		reader, err := zip.OpenReader(filepath.Join(dir, name+".zip"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files map[string]string
+		if password != "" {
+			files = decryptZip(t, &reader.Reader, password)
+		} else {
+			files = readTestZip(t, filepath.Join(dir, name+".zip"))
+		}
+		reader.Close()
+
+		var manifest internal.Manifest
+		if err := json.Unmarshal([]byte(files[internal.ManifestName]), &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Files) != 2 || manifest.Files[0].Name != "docs/b.pdf" || manifest.Files[1].Name != "c.pdf" {
+			t.Fatalf("Manifest %s: %+v", name, manifest)
+		}
+		want := sha256Hex("content of /b.pdf") + "  docs/b.pdf\n" + sha256Hex("content of /c.pdf") + "  c.pdf\n"
+		if files[internal.ChecksumsName] != want {
+			t.Fatalf("SHA256SUMS %s: %q", name, files[internal.ChecksumsName])
+		}
+		if len(files) != 4 {
+			t.Fatalf("Files %s: %v", name, files)
+		}
+	}
+}
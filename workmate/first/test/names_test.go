@@ -0,0 +1,87 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGeneratedNames(t *testing.T) {
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+	ts := newServer(storage)
+	defer ts.Close()
+
+	create := func(body string) map[string]string {
+		resp, err := http.Post(ts.URL+"/create", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Create status: %d", resp.StatusCode)
+		}
+		var created map[string]string
+		json.NewDecoder(resp.Body).Decode(&created)
+		return created
+	}
+
+	ulid := regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}\.zip$`)
+
+	// одно и то же имя клиента не мешает создать несколько архивов
+	first := create(`{"filename": "report", "generate_name": true}`)
+	second := create(`{"filename": "report", "generate_name": true}`)
+	if !ulid.MatchString(first["filename"]) || first["filename"] == second["filename"] {
+		t.Fatalf("Generated names: %v %v", first, second)
+	}
+	if first["display_name"] != "report.zip" || second["display_name"] != "report.zip" {
+		t.Fatalf("Display names: %v %v", first, second)
+	}
+
+	// без имени сервер тоже придумывает его сам
+	if unnamed := create(`{}`); !ulid.MatchString(unnamed["filename"]) || unnamed["display_name"] != unnamed["filename"] {
+		t.Fatalf("Unnamed archive: %v", unnamed)
+	}
+
+	// архив скачивается под именем клиента
+	resp, err := http.Get(ts.URL + "/archives/" + first["filename"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != `attachment; filename=report.zip` {
+		t.Fatalf("Content-Disposition: %s", disposition)
+	}
+}
+
+func TestArchiveNameOutsideStorage(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(filepath.Join(dir, "archives"), time.Hour)
+	os.MkdirAll(filepath.Join(dir, "archives"), 0755)
+
+	// архив рядом с папкой хранилища, до него не должно быть доступа
+	secret := filepath.Join(dir, "secret.zip")
+	if err := os.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	for _, path := range []string{"/create", "/add", "/download", "/downloadanddelete"} {
+		body := `{"filename": "../secret", "urls": ["http://example.com/a.txt"]}`
+		if status := post(t, ts.URL+path, body); status != http.StatusBadRequest {
+			t.Fatalf("%s status: %d", path, status)
+		}
+	}
+
+	data, err := os.ReadFile(secret)
+	if err != nil || string(data) != "secret" {
+		t.Fatalf("Secret archive changed: %q %v", data, err)
+	}
+}
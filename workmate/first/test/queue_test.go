@@ -0,0 +1,257 @@
+package test
+
+import (
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAddToZipTask(t *testing.T) {
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "task"}`)
+
+	body := fmt.Sprintf(`{"filename": "task", "urls": ["%s/a.pdf", "not a url"]}`, origin.URL)
+	task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body))
+
+	if task.Status != internal.TaskDone || task.Link != "/archives/task.zip" {
+		t.Fatalf("Task: %+v", task)
+	}
+	if len(task.Errors) != 1 || task.Errors[0].URL != "not a url" {
+		t.Fatalf("Task errors: %+v", task.Errors)
+	}
+
+	resp, err := http.Get(ts.URL + "/tasks/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Unknown task status: %d", resp.StatusCode)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// источник отвечает только когда его отпустят
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("data"))
+	}))
+	defer origin.Close()
+	defer close(release)
+
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+	queue := internal.NewQueue(1, 1)
+	downloadHandler := internal.NewHandler(queue, internal.NewRateLimiter(3))
+	downloadHandler.SetStorage(storage)
+
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "busy"}`)
+
+	body := fmt.Sprintf(`{"filename": "busy", "urls": ["%s/a.pdf"]}`, origin.URL)
+
+	// первая задача занимает воркер, вторая ждёт в очереди, третья не помещается
+	addTask(t, ts.URL+"/add", body)
+	time.Sleep(50 * time.Millisecond)
+	addTask(t, ts.URL+"/add", body)
+
+	if status := post(t, ts.URL+"/add", body); status != http.StatusServiceUnavailable {
+		t.Fatalf("Full queue status: %d", status)
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	queue := internal.NewQueue(1, 10)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	queue.Start(func(task *internal.Task) error {
+		started <- task.FileName
+		if task.FileName == "first" {
+			<-release
+		}
+		return nil
+	})
+
+	submit := func(name string, priority string) {
+		t.Helper()
+		if err := queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: name, Priority: priority}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// единственный воркер занят, остальные задачи ждут в очереди
+	submit("first", internal.PriorityInteractive)
+	if name := <-started; name != "first" {
+		t.Fatalf("Started: %s", name)
+	}
+	submit("batch1", internal.PriorityBatch)
+	submit("batch2", internal.PriorityBatch)
+	submit("interactive", internal.PriorityInteractive)
+	close(release)
+
+	want := []string{"interactive", "batch1", "batch2"}
+	for _, name := range want {
+		select {
+		case got := <-started:
+			if got != name {
+				t.Fatalf("Started %s, want %s", got, name)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Task %s not started", name)
+		}
+	}
+}
+
+func TestQueuePriorityAging(t *testing.T) {
+	queue := internal.NewQueue(1, 10)
+	queue.SetPriorities(0, 100*time.Millisecond)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	queue.Start(func(task *internal.Task) error {
+		started <- task.FileName
+		if task.FileName == "first" {
+			<-release
+		}
+		return nil
+	})
+
+	queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: "first"})
+	<-started
+	queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: "batch", Priority: internal.PriorityBatch})
+	time.Sleep(150 * time.Millisecond)
+	queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: "interactive"})
+	close(release)
+
+	// batch ждёт дольше aging и идёт первым
+	if name := <-started; name != "batch" {
+		t.Fatalf("Started: %s", name)
+	}
+}
+
+func TestQueueWithoutCapacity(t *testing.T) {
+	queue := internal.NewQueue(3, 0)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	defer close(release)
+	queue.Start(func(task *internal.Task) error {
+		started <- task.FileName
+		<-release
+		return nil
+	})
+
+	// ждём пока все воркеры станут свободны
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Idle() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("Workers not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// без очереди задачи берут свободные воркеры, лишняя не помещается
+	for i := 0; i < 3; i++ {
+		if err := queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("Task %d: %v", i, err)
+		}
+	}
+	if err := queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: "extra"}); err != internal.ErrQueueFull {
+		t.Fatalf("Extra task: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Task not started")
+		}
+	}
+}
+
+func TestQueueReservedWorkers(t *testing.T) {
+	queue := internal.NewQueue(3, 10)
+	queue.SetPriorities(1, 0)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	defer close(release)
+	queue.Start(func(task *internal.Task) error {
+		started <- task.FileName
+		if task.Priority == internal.PriorityBatch {
+			<-release
+		}
+		return nil
+	})
+
+	// batch задачи занимают все незарезервированные воркеры, третья ждёт
+	for i := 0; i < 3; i++ {
+		if err := queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: fmt.Sprint("batch", i), Priority: internal.PriorityBatch}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+	select {
+	case name := <-started:
+		t.Fatalf("Batch task on reserved worker: %s", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// interactive задача не ждёт окончания batch
+	if err := queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: "interactive", Priority: internal.PriorityInteractive}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-started:
+		if name != "interactive" {
+			t.Fatalf("Started: %s", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Interactive task waits for batch workers")
+	}
+}
+
+func TestQueueReservedAging(t *testing.T) {
+	queue := internal.NewQueue(2, 10)
+	queue.SetPriorities(1, 100*time.Millisecond)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	defer close(release)
+	queue.Start(func(task *internal.Task) error {
+		started <- task.FileName
+		<-release
+		return nil
+	})
+
+	for _, name := range []string{"batch0", "batch1"} {
+		queue.Submit(&internal.Task{Kind: internal.TaskAdd, FileName: name, Priority: internal.PriorityBatch})
+	}
+	<-started
+
+	// batch, который ждёт дольше aging, получает зарезервированный воркер
+	select {
+	case name := <-started:
+		t.Fatalf("Batch task on reserved worker before aging: %s", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Aged batch task not started")
+	}
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// кладём в хранилище архив нужного размера с метаданными
+func putArchive(t *testing.T, storage *internal.Storage, dir string, filename string, size int, used time.Time, pinned bool) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, filename), make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := storage.WriteMeta(internal.ArchiveMeta{
+		FileName:   filename,
+		CreatedAt:  used,
+		ModifiedAt: used,
+		AccessedAt: used,
+		Pinned:     pinned,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotaReject(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+	storage.SetQuota(internal.QuotaConfig{MaxBytes: 100, Policy: internal.QuotaReject})
+
+	putArchive(t, storage, dir, "a.zip", 80, time.Now(), false)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 50)))
+	}))
+	defer origin.Close()
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	if status := post(t, ts.URL+"/create", `{"filename": "b", "owner": "alice"}`); status != http.StatusOK {
+		t.Fatalf("Create status: %d", status)
+	}
+
+	// 80 + 50 не влезает в 100
+	body := `{"filename": "b", "urls": ["` + origin.URL + `/file.pdf"]}`
+	task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body))
+	if task.Status != internal.TaskFailed || task.Error != internal.ErrQuotaExceeded.Error() {
+		t.Fatalf("Add task: %+v", task)
+	}
+
+	resp, err := http.Get(ts.URL + "/usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var usage internal.Usage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		t.Fatal(err)
+	}
+	// пустой архив alice тоже занимает место
+	if usage.TotalBytes != 80+usage.Owners["alice"] || usage.Owners["alice"] == 0 || usage.MaxBytes != 100 {
+		t.Fatalf("Usage: %+v", usage)
+	}
+}
+
+func TestQuotaEvict(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+	storage.SetQuota(internal.QuotaConfig{MaxBytes: 100, Policy: internal.QuotaEvict})
+
+	putArchive(t, storage, dir, "pinned.zip", 40, time.Now().Add(-3*time.Hour), true)
+	putArchive(t, storage, dir, "old.zip", 40, time.Now().Add(-2*time.Hour), false)
+	putArchive(t, storage, dir, "new.zip", 40, time.Now().Add(-time.Hour), false)
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	if status := post(t, ts.URL+"/create", `{"filename": "fresh"}`); status != http.StatusOK {
+		t.Fatalf("Create status: %d", status)
+	}
+
+	// закреплённый не трогаем, удаляется самый давно используемый из остальных
+	if _, err := os.Stat(filepath.Join(dir, "old.zip")); !os.IsNotExist(err) {
+		t.Fatalf("LRU archive not evicted: %v", err)
+	}
+	for _, name := range []string{"pinned.zip", "new.zip", "fresh.zip"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Archive %s removed: %v", name, err)
+		}
+	}
+}
+
+func TestOwnerQuota(t *testing.T) {
+	dir := t.TempDir()
+	storage := internal.NewStorage(dir, time.Hour)
+	storage.SetQuota(internal.QuotaConfig{OwnerMaxBytes: 50, Policy: internal.QuotaReject})
+
+	putArchive(t, storage, dir, "bob.zip", 60, time.Now(), false)
+	meta, _ := storage.ReadMeta("bob.zip")
+	meta.Owner = "bob"
+	storage.WriteMeta(meta)
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	if status := post(t, ts.URL+"/create", `{"filename": "bob2", "owner": "bob"}`); status != http.StatusInsufficientStorage {
+		t.Fatalf("Create over owner quota status: %d", status)
+	}
+	if status := post(t, ts.URL+"/create", `{"filename": "carol", "owner": "carol"}`); status != http.StatusOK {
+		t.Fatalf("Create other owner status: %d", status)
+	}
+}
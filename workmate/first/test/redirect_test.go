@@ -0,0 +1,102 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// источник с цепочками редиректов: /hop/N ведёт на /hop/N-1, /other - на другой хост
+func newRedirectOrigin(other string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/hop/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hop/"))
+			if n > 0 {
+				http.Redirect(w, r, fmt.Sprintf("/hop/%d", n-1), http.StatusFound)
+				return
+			}
+			w.Write([]byte("arrived"))
+		case r.URL.Path == "/other":
+			http.Redirect(w, r, other+"/file.txt", http.StatusMovedPermanently)
+		default:
+			w.Write([]byte("other host"))
+		}
+	}))
+}
+
+// собираем архив с настройками скачивания cfg и возвращаем отчёт по ссылкам
+func downloadResults(t *testing.T, cfg internal.DownloadsConfig, urls []string) []internal.URLResult {
+	downloadHandler, _ := newHandler(internal.NewStorage(t.TempDir(), time.Hour))
+	if err := downloadHandler.SetDownloads(cfg); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	encoded, _ := json.Marshal(urls)
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(fmt.Sprintf(`{"urls": %s}`, encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	// если ни одна ссылка не скачалась, отчёт приходит в теле
+	report := resp.Trailer.Get(internal.ResultsTrailer)
+	if resp.StatusCode == http.StatusPartialContent {
+		report = string(data)
+	}
+
+	var doc internal.ResultDocument
+	if err := json.Unmarshal([]byte(report), &doc); err != nil {
+		t.Fatalf("Status %d, trailer: %v", resp.StatusCode, err)
+	}
+	return doc.Results
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other host"))
+	}))
+	defer other.Close()
+	origin := newRedirectOrigin(other.URL)
+	defer origin.Close()
+
+	urls := []string{origin.URL + "/hop/2", origin.URL + "/hop/5", origin.URL + "/other", origin.URL + "/plain"}
+
+	// не больше 3 редиректов и только в пределах хоста
+	results := downloadResults(t, internal.DownloadsConfig{Redirects: internal.RedirectPolicy{MaxHops: 3, SameHost: true}}, urls)
+	if results[0].Status != internal.ResultOK || results[0].FinalURL != origin.URL+"/hop/0" {
+		t.Fatalf("Allowed chain: %+v", results[0])
+	}
+	if results[1].ErrorClass != internal.ErrorClassRedirect || !strings.Contains(results[1].Error, "too many redirects") {
+		t.Fatalf("Long chain: %+v", results[1])
+	}
+	if results[2].ErrorClass != internal.ErrorClassRedirect || !strings.Contains(results[2].Error, "other host") {
+		t.Fatalf("Other host: %+v", results[2])
+	}
+	if results[3].Status != internal.ResultOK || results[3].FinalURL != "" {
+		t.Fatalf("Without redirect: %+v", results[3])
+	}
+
+	// без ограничения по хосту редирект на другой хост проходит
+	results = downloadResults(t, internal.DownloadsConfig{Redirects: internal.RedirectPolicy{MaxHops: 3}}, urls[2:3])
+	if results[0].Status != internal.ResultOK || results[0].FinalURL != other.URL+"/file.txt" {
+		t.Fatalf("Other host allowed: %+v", results[0])
+	}
+
+	// 0 - редиректы запрещены
+	results = downloadResults(t, internal.DownloadsConfig{}, urls[:1])
+	if results[0].ErrorClass != internal.ErrorClassRedirect {
+		t.Fatalf("Redirects disabled: %+v", results[0])
+	}
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// источник с разными ответами для отчёта по ссылкам
+func newResultsOrigin() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("pdf"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/big.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte(strings.Repeat("x", 1000)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestResultDocument(t *testing.T) {
+	origin := newResultsOrigin()
+	defer origin.Close()
+
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+	downloadHandler, _ := newHandler(storage)
+	downloadHandler.SetDownloads(internal.DownloadsConfig{MaxBytes: 100, AllowedTypes: []string{"application/pdf"}})
+
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	urls := []string{
+		origin.URL + "/ok.pdf",
+		origin.URL + "/missing.pdf",
+		origin.URL + "/page.html",
+		origin.URL + "/big.pdf",
+		"http://archive-test.invalid/a.pdf",
+		"not a url",
+	}
+	want := []struct {
+		status     string
+		class      string
+		httpStatus int
+	}{
+		{internal.ResultOK, "", 0},
+		{internal.ResultFailed, internal.ErrorClassHTTPStatus, http.StatusNotFound},
+		{internal.ResultFailed, internal.ErrorClassTypeRejected, 0},
+		{internal.ResultFailed, internal.ErrorClassTooLarge, 0},
+		{internal.ResultFailed, internal.ErrorClassDNS, 0},
+		{internal.ResultFailed, internal.ErrorClassInvalidURL, 0},
+	}
+	check := func(name string, doc internal.ResultDocument) {
+		t.Helper()
+		if len(doc.Results) != len(want) {
+			t.Fatalf("%s: results %+v", name, doc.Results)
+		}
+		for i, result := range doc.Results {
+			if result.URL != urls[i] || result.Status != want[i].status || result.ErrorClass != want[i].class || result.HTTPStatus != want[i].httpStatus {
+				t.Fatalf("%s: result %d: %+v", name, i, result)
+			}
+		}
+		if doc.Results[0].Name != "ok.pdf" || doc.Results[0].Size != 3 {
+			t.Fatalf("%s: ok result %+v", name, doc.Results[0])
+		}
+	}
+
+	encoded, _ := json.Marshal(urls)
+
+	// архив в ответе: отчёт в трейлере после архива
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(fmt.Sprintf(`{"urls": %s}`, encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Errors") != "true" {
+		t.Fatalf("Download status: %d", resp.StatusCode)
+	}
+
+	var doc internal.ResultDocument
+	if err := json.Unmarshal([]byte(resp.Trailer.Get(internal.ResultsTrailer)), &doc); err != nil {
+		t.Fatalf("Trailer %q: %v", resp.Trailer.Get(internal.ResultsTrailer), err)
+	}
+	check("download", doc)
+
+	// архив на сервере: тот же отчёт в json по задаче
+	post(t, ts.URL+"/create", `{"filename": "results"}`)
+	id := addTask(t, ts.URL+"/add", fmt.Sprintf(`{"filename": "results", "urls": %s}`, encoded))
+	waitTask(t, ts.URL, id)
+
+	resp, err = http.Get(ts.URL + "/tasks/" + id + "/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	doc = internal.ResultDocument{}
+	json.NewDecoder(resp.Body).Decode(&doc)
+	if doc.TaskID != id || doc.Status != internal.TaskDone || doc.Link != "/archives/results.zip" {
+		t.Fatalf("Add document: %+v", doc)
+	}
+	check("add", doc)
+}
@@ -13,9 +13,9 @@ import (
 )
 
 func TestDownloadAndZip(t *testing.T) {
-	limiter := internal.NewRateLimiter(3)
+	queue := internal.NewQueue(3, 0)
 	limiterdownload := internal.NewRateLimiter(3)
-	downloadHandler := internal.NewHandler(limiter, limiterdownload)
+	downloadHandler := internal.NewHandler(queue, limiterdownload)
 
 	ts := httptest.NewServer(http.HandlerFunc(downloadHandler.DownloadAndZip))
 	defer ts.Close()
@@ -59,9 +59,9 @@ func TestDownloadAndZip(t *testing.T) {
 }
 
 func TestLimiter(t *testing.T) {
-	limiter := internal.NewRateLimiter(3)
+	queue := internal.NewQueue(3, 0)
 	limiterdownload := internal.NewRateLimiter(3)
-	downloadHandler := internal.NewHandler(limiter, limiterdownload)
+	downloadHandler := internal.NewHandler(queue, limiterdownload)
 
 	ts := httptest.NewServer(http.HandlerFunc(downloadHandler.DownloadAndZip))
 	defer ts.Close()
@@ -77,7 +77,8 @@ func TestLimiter(t *testing.T) {
 
 			req, err := http.NewRequest("POST", ts.URL, requestBody)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 
 			req.Header.Set("Content-Type", "application/json")
@@ -85,7 +86,8 @@ func TestLimiter(t *testing.T) {
 			client := &http.Client{}
 			resp, err := client.Do(req)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 			defer resp.Body.Close()
 
@@ -101,13 +103,15 @@ func TestLimiter(t *testing.T) {
 			outputPath := filepath.Join("./files", "downloaded.zip")
 			outFile, err := os.Create(outputPath)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 			defer outFile.Close()
 
 			_, err = io.Copy(outFile, resp.Body)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 
 			t.Logf("zip saved")
@@ -118,9 +122,9 @@ func TestLimiter(t *testing.T) {
 }
 
 func TestCreateZip(t *testing.T) {
-	limiter := internal.NewRateLimiter(3)
+	queue := internal.NewQueue(3, 0)
 	limiterdownload := internal.NewRateLimiter(3)
-	downloadHandler := internal.NewHandler(limiter, limiterdownload)
+	downloadHandler := internal.NewHandler(queue, limiterdownload)
 
 	ts := httptest.NewServer(http.HandlerFunc(downloadHandler.CreateZip))
 	defer ts.Close()
@@ -136,7 +140,8 @@ func TestCreateZip(t *testing.T) {
 
 			req, err := http.NewRequest("POST", ts.URL, requestBody)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 
 			req.Header.Set("Content-Type", "application/json")
@@ -144,7 +149,8 @@ func TestCreateZip(t *testing.T) {
 			client := &http.Client{}
 			resp, err := client.Do(req)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 			defer resp.Body.Close()
 
@@ -157,9 +163,9 @@ func TestCreateZip(t *testing.T) {
 }
 
 func TestAddToZip(t *testing.T) {
-	limiter := internal.NewRateLimiter(3)
+	queue := internal.NewQueue(3, 0)
 	limiterdownload := internal.NewRateLimiter(3)
-	downloadHandler := internal.NewHandler(limiter, limiterdownload)
+	downloadHandler := internal.NewHandler(queue, limiterdownload)
 
 	router := http.NewServeMux()
 	router.HandleFunc("/create", downloadHandler.CreateZip)
@@ -193,7 +199,8 @@ func TestAddToZip(t *testing.T) {
 
 			req, err := http.NewRequest("POST", ts.URL+"/add", requestBody)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 
 			req.Header.Set("Content-Type", "application/json")
@@ -201,7 +208,8 @@ func TestAddToZip(t *testing.T) {
 			client := &http.Client{}
 			resp, err := client.Do(req)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 			defer resp.Body.Close()
 
@@ -214,9 +222,9 @@ func TestAddToZip(t *testing.T) {
 }
 
 func TestDownloadZip(t *testing.T) {
-	limiter := internal.NewRateLimiter(3)
+	queue := internal.NewQueue(3, 0)
 	limiterdownload := internal.NewRateLimiter(3)
-	downloadHandler := internal.NewHandler(limiter, limiterdownload)
+	downloadHandler := internal.NewHandler(queue, limiterdownload)
 
 	router := http.NewServeMux()
 	router.HandleFunc("/create", downloadHandler.CreateZip)
@@ -0,0 +1,493 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// сохраняем PEM в файл, возвращаем путь
+func writePEM(t testing.TB, blockType string, der []byte) string {
+	file, err := os.CreateTemp(t.TempDir(), "*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pem.Encode(file, &pem.Block{Type: blockType, Bytes: der})
+	return file.Name()
+}
+
+// Клиентский сертификат, подписанный своим CA: пул с CA для сервера и файлы сертификата и ключа
+func newClientCert(t *testing.T) (*x509.CertPool, string, string) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "zipper"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, writePEM(t, "CERTIFICATE", der), writePEM(t, "EC PRIVATE KEY", keyDER)
+}
+
+// https источник с настройками TLS сервера
+func newTLSOrigin(config *tls.Config) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	ts.TLS = config
+	// отказы в рукопожатии ожидаемы, в вывод тестов их не пишем
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	return ts
+}
+
+func TestTransportTLS(t *testing.T) {
+	origin := newTLSOrigin(nil)
+	defer origin.Close()
+	rootCA := writePEM(t, "CERTIFICATE", origin.Certificate().Raw)
+
+	// сертификат источника не из системных - без своего CA не скачивается
+	results := downloadResults(t, internal.DownloadsConfig{}, []string{origin.URL + "/a.txt"})
+	if results[0].ErrorClass != internal.ErrorClassTLS {
+		t.Fatalf("Unknown CA: %+v", results[0])
+	}
+	results = downloadResults(t, internal.DownloadsConfig{Transport: internal.TransportConfig{RootCAs: []string{rootCA}}}, []string{origin.URL + "/a.txt"})
+	if results[0].Status != internal.ResultOK {
+		t.Fatalf("Own CA: %+v", results[0])
+	}
+
+	// источник умеет только TLS 1.2, а требуем 1.3
+	old := newTLSOrigin(&tls.Config{MaxVersion: tls.VersionTLS12})
+	defer old.Close()
+	oldCA := writePEM(t, "CERTIFICATE", old.Certificate().Raw)
+	results = downloadResults(t, internal.DownloadsConfig{Transport: internal.TransportConfig{
+		RootCAs:       []string{oldCA},
+		MinTLSVersion: "1.3",
+	}}, []string{old.URL + "/a.txt"})
+	if results[0].ErrorClass != internal.ErrorClassTLS {
+		t.Fatalf("Min TLS version: %+v", results[0])
+	}
+
+	// на порту источника не TLS: вместо рукопожатия приходит мусор
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("\x00\x01\x02\x03\x04\x05\x06\x07"))
+			conn.Close()
+		}
+	}()
+	results = downloadResults(t, internal.DownloadsConfig{}, []string{"https://" + listener.Addr().String() + "/a.txt"})
+	if results[0].ErrorClass != internal.ErrorClassTLS {
+		t.Fatalf("Not TLS: %+v", results[0])
+	}
+
+	// источник требует клиентский сертификат
+	clientCAs, clientCert, clientKey := newClientCert(t)
+	mtls := newTLSOrigin(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs})
+	defer mtls.Close()
+	mtlsCA := writePEM(t, "CERTIFICATE", mtls.Certificate().Raw)
+
+	results = downloadResults(t, internal.DownloadsConfig{Transport: internal.TransportConfig{RootCAs: []string{mtlsCA}}}, []string{mtls.URL + "/a.txt"})
+	if results[0].ErrorClass != internal.ErrorClassTLS {
+		t.Fatalf("mTLS without certificate: %+v", results[0])
+	}
+	results = downloadResults(t, internal.DownloadsConfig{Transport: internal.TransportConfig{
+		RootCAs:    []string{mtlsCA},
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+	}}, []string{mtls.URL + "/a.txt"})
+	if results[0].Status != internal.ResultOK {
+		t.Fatalf("mTLS with certificate: %+v", results[0])
+	}
+}
+
+func TestRedirectDowngrade(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.RedirectHandler(plain.URL+"/file.txt", http.StatusFound))
+	defer secure.Close()
+	rootCA := writePEM(t, "CERTIFICATE", secure.Certificate().Raw)
+
+	cfg := internal.DownloadsConfig{
+		Redirects: internal.RedirectPolicy{MaxHops: 3},
+		Transport: internal.TransportConfig{RootCAs: []string{rootCA}},
+	}
+	results := downloadResults(t, cfg, []string{secure.URL + "/file.txt"})
+	if results[0].ErrorClass != internal.ErrorClassRedirect {
+		t.Fatalf("Downgrade: %+v", results[0])
+	}
+
+	cfg.Redirects.AllowDowngrade = true
+	results = downloadResults(t, cfg, []string{secure.URL + "/file.txt"})
+	if results[0].Status != internal.ResultOK || results[0].FinalURL != plain.URL+"/file.txt" {
+		t.Fatalf("Allowed downgrade: %+v", results[0])
+	}
+}
+
+// http прокси: обычные запросы пересылает, для https открывает туннель через CONNECT
+func newHTTPProxy(requests *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		if r.Method == http.MethodConnect {
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			go pipe(conn, target)
+			return
+		}
+
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+}
+
+// socks5 прокси без авторизации, только CONNECT
+func newSOCKS5Proxy(t *testing.T, requests *atomic.Int32) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				target, err := socks5Handshake(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				requests.Add(1)
+				go pipe(conn, target)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func socks5Handshake(conn net.Conn) (net.Conn, error) {
+	// приветствие: версия, число методов, методы
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return nil, err
+	}
+	conn.Write([]byte{5, 0})
+
+	// запрос: версия, команда, резерв, тип адреса, адрес, порт
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, err
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		size := make([]byte, 1)
+		io.ReadFull(conn, size)
+		name := make([]byte, size[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	case 4:
+		ip := make([]byte, 16)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return target, nil
+}
+
+// гоняем байты в обе стороны, пока одна из сторон не закроется
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	go io.Copy(a, b)
+	io.Copy(b, a)
+}
+
+func TestTransportProxy(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+	secure := newTLSOrigin(nil)
+	defer secure.Close()
+	rootCA := writePEM(t, "CERTIFICATE", secure.Certificate().Raw)
+
+	var httpRequests, socksRequests atomic.Int32
+	proxy := newHTTPProxy(&httpRequests)
+	defer proxy.Close()
+	socks := newSOCKS5Proxy(t, &socksRequests)
+
+	urls := []string{origin.URL + "/a.pdf", secure.URL + "/b.txt"}
+
+	// http прокси: обычный запрос и туннель для https
+	results := downloadResults(t, internal.DownloadsConfig{Transport: internal.TransportConfig{
+		Proxy:   proxy.URL,
+		RootCAs: []string{rootCA},
+	}}, urls)
+	for _, result := range results {
+		if result.Status != internal.ResultOK {
+			t.Fatalf("HTTP proxy: %+v", result)
+		}
+	}
+	if httpRequests.Load() != 2 {
+		t.Fatalf("HTTP proxy requests: %d", httpRequests.Load())
+	}
+
+	results = downloadResults(t, internal.DownloadsConfig{Transport: internal.TransportConfig{
+		Proxy:   "socks5://" + socks,
+		RootCAs: []string{rootCA},
+	}}, urls)
+	for _, result := range results {
+		if result.Status != internal.ResultOK {
+			t.Fatalf("SOCKS5 proxy: %+v", result)
+		}
+	}
+	if socksRequests.Load() != 2 {
+		t.Fatalf("SOCKS5 proxy requests: %d", socksRequests.Load())
+	}
+
+	// неправильные настройки не принимаются
+	queue := internal.NewQueue(1, 1)
+	downloadHandler := internal.NewHandler(queue, internal.NewRateLimiter(1))
+	for _, transport := range []internal.TransportConfig{
+		{Proxy: "ftp://proxy:21"},
+		{MinTLSVersion: "2.0"},
+		{RootCAs: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+	} {
+		if err := downloadHandler.SetDownloads(internal.DownloadsConfig{Transport: transport}); err == nil {
+			t.Fatalf("Accepted transport config: %+v", transport)
+		}
+	}
+}
+
+// https источник с HTTP/2, считает новые соединения и отвечает версией протокола
+func newPoolOrigin(conns *atomic.Int32) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.EnableHTTP2 = true
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.StartTLS()
+	return ts
+}
+
+// сервер архивов с настройками транспорта, источнику с самоподписанным сертификатом доверяем.
+// slots - сколько файлов скачивается одновременно
+func newTransportServer(tb testing.TB, origin *httptest.Server, transport internal.TransportConfig, slots int) *httptest.Server {
+	transport.RootCAs = []string{writePEM(tb, "CERTIFICATE", origin.Certificate().Raw)}
+
+	queue := internal.NewQueue(3, 10)
+	downloadHandler := internal.NewHandler(queue, internal.NewRateLimiter(slots))
+	downloadHandler.SetStorage(internal.NewStorage(tb.TempDir(), time.Hour))
+	if err := downloadHandler.SetDownloads(internal.DownloadsConfig{Transport: transport}); err != nil {
+		tb.Fatal(err)
+	}
+	return httptest.NewServer(newRouter(downloadHandler))
+}
+
+// тело запроса на архив из n файлов одного источника
+func poolRequest(origin string, n int) string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = origin + "/" + strconv.Itoa(i) + ".txt"
+	}
+	encoded, _ := json.Marshal(urls)
+	return `{"urls": ` + string(encoded) + `}`
+}
+
+func TestTransportReuse(t *testing.T) {
+	var conns atomic.Int32
+	origin := newPoolOrigin(&conns)
+	defer origin.Close()
+
+	for _, tc := range []struct {
+		name  string
+		http2 bool
+	}{{"http2", true}, {"http1", false}} {
+		t.Run(tc.name, func(t *testing.T) {
+			conns.Store(0)
+			// файлы скачиваются по одному: соединение возвращается в пул, когда тело прочитано
+			// до конца, поэтому следующий файл всегда берёт его, а не открывает новое
+			ts := newTransportServer(t, origin, internal.TransportConfig{DisableHTTP2: !tc.http2}, 1)
+			defer ts.Close()
+
+			// два архива по 6 файлов: все 12 файлов идут по одному соединению
+			var files map[string]string
+			for i := 0; i < 2; i++ {
+				data := downloadZip(t, ts.URL, poolRequest(origin.URL, 6))
+				reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				files = readZipFiles(t, reader, "0.txt", "5.txt")
+			}
+
+			proto := "HTTP/1.1"
+			if tc.http2 {
+				proto = "HTTP/2.0"
+			}
+			if files["0.txt"] != proto || files["5.txt"] != proto {
+				t.Fatalf("Protocol: %v", files)
+			}
+			// источник один, значит и соединение одно
+			if n := conns.Load(); n != 1 {
+				t.Fatalf("New connections for 12 files: %d", n)
+			}
+		})
+	}
+}
+
+// Один и тот же https источник: общий транспорт против соединения на каждый файл.
+// Источник без keep-alive заставляет каждый раз заново делать рукопожатие TLS.
+// go test -bench Transport ./test/
+func BenchmarkTransport(b *testing.B) {
+	for _, tc := range []struct {
+		name      string
+		keepAlive bool
+	}{{"pooled", true}, {"per-request", false}} {
+		b.Run(tc.name, func(b *testing.B) {
+			var conns atomic.Int32
+			origin := newPoolOrigin(&conns)
+			origin.Config.SetKeepAlivesEnabled(tc.keepAlive)
+			defer origin.Close()
+			ts := newTransportServer(b, origin, internal.TransportConfig{DisableHTTP2: !tc.keepAlive}, 3)
+			defer ts.Close()
+
+			body := poolRequest(origin.URL, 8)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				downloadZip(b, ts.URL, body)
+			}
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
+
+func TestS3Transport(t *testing.T) {
+	// хранилище по https с сертификатом, которому доверяем только через root_cas
+	fake := newFakeS3(map[string]string{"/assets/a.txt": "from s3"}, "AKIDTEST", "s3secret")
+	fake.Close()
+	s3 := httptest.NewTLSServer(fake.Config.Handler)
+	defer s3.Close()
+	transport := internal.TransportConfig{RootCAs: []string{writePEM(t, "CERTIFICATE", s3.Certificate().Raw)}}
+	s3Config := internal.S3Config{Endpoint: s3.URL, AccessKey: "AKIDTEST", SecretKey: "s3secret", PathStyle: true}
+
+	// s3 настраивается до и после настроек скачивания, транспорт в обоих случаях общий
+	for _, s3First := range []bool{true, false} {
+		downloadHandler, _ := newHandler(internal.NewStorage(t.TempDir(), time.Hour))
+		if s3First {
+			downloadHandler.SetS3(s3Config)
+		}
+		if err := downloadHandler.SetDownloads(internal.DownloadsConfig{Transport: transport}); err != nil {
+			t.Fatal(err)
+		}
+		if !s3First {
+			downloadHandler.SetS3(s3Config)
+		}
+		ts := httptest.NewServer(newRouter(downloadHandler))
+
+		data := downloadZip(t, ts.URL, `{"urls": ["s3://assets/a.txt"]}`)
+		ts.Close()
+		reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files := readZipFiles(t, reader, "a.txt"); files["a.txt"] != "from s3" {
+			t.Fatalf("S3 first %v: %v", s3First, files)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookCallback(t *testing.T) {
+	origin := newOrigin()
+	defer origin.Close()
+
+	// приёмник уведомлений: первая попытка падает, вторая принимается
+	var mu sync.Mutex
+	var calls int
+	received := make(chan internal.WebhookPayload, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(internal.SignatureHeader) != internal.Sign("secret", body) {
+			t.Errorf("Bad signature: %s", r.Header.Get(internal.SignatureHeader))
+		}
+
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+
+		if first {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var payload internal.WebhookPayload
+		json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+	downloadHandler, queue := newHandler(storage)
+	notifier := internal.NewNotifier(internal.WebhookConfig{
+		Secret:   "secret",
+		Attempts: 3,
+		Backoff:  internal.Duration(10 * time.Millisecond),
+		BaseURL:  "http://archives.local",
+	})
+	downloadHandler.SetNotifier(notifier)
+
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "hook"}`)
+
+	if status := post(t, ts.URL+"/add", `{"filename": "hook", "urls": ["x"], "callback_url": "ftp://bad"}`); status != http.StatusBadRequest {
+		t.Fatalf("Bad callback status: %d", status)
+	}
+
+	body := fmt.Sprintf(`{"filename": "hook", "urls": ["%s/a.pdf", "bad url"], "callback_url": "%s"}`, origin.URL, receiver.URL)
+	id := addTask(t, ts.URL+"/add", body)
+
+	var payload internal.WebhookPayload
+	select {
+	case payload = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook not received")
+	}
+
+	if payload.TaskID != id || payload.Status != internal.TaskDone || payload.Link != "http://archives.local/archives/hook.zip" {
+		t.Fatalf("Payload: %+v", payload)
+	}
+	if len(payload.Errors) != 1 || payload.Errors[0].URL != "bad url" {
+		t.Fatalf("Payload errors: %+v", payload.Errors)
+	}
+
+	// попытка записывается в журнал доставки после ответа приёмника
+	var deliveries []internal.Delivery
+	for i := 0; i < 100 && len(deliveries) < 2; i++ {
+		resp, err := http.Get(ts.URL + "/tasks/" + id + "/deliveries")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&deliveries)
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(deliveries) != 2 || deliveries[0].StatusCode != http.StatusInternalServerError || deliveries[1].StatusCode != http.StatusOK {
+		t.Fatalf("Deliveries: %+v", deliveries)
+	}
+
+	// очередь забыла задачу - попытки доставки тоже забываются
+	queue.SetRetention(0)
+	if n := queue.Prune(); n != 1 {
+		t.Fatalf("Pruned: %d", n)
+	}
+	if deliveries := notifier.Deliveries(id); len(deliveries) != 0 {
+		t.Fatalf("Deliveries after prune: %+v", deliveries)
+	}
+}
@@ -0,0 +1,201 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/maximsavonin/Tests/workmate/first/internal"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// больше 4 ГиБ, чтобы размеры и смещения не влезали в 32 бита
+const hugeSize = 4<<30 + 1<<20
+
+// бесконечный поток нулей, файл такого размера в памяти не нужен
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// пишет нули пропуском, на диске получается разреженный файл
+type sparseWriter struct {
+	file *os.File
+}
+
+func (w sparseWriter) Write(p []byte) (int, error) {
+	if bytes.Count(p, []byte{0}) == len(p) {
+		_, err := w.file.Seek(int64(len(p)), io.SeekCurrent)
+		return len(p), err
+	}
+	return w.file.Write(p)
+}
+
+func TestZip64DownloadAndZip(t *testing.T) {
+	// архив на 4 ГиБ собирается долго, запускаем только по ZIP64_TEST=1
+	if testing.Short() || os.Getenv("ZIP64_TEST") != "1" {
+		t.Skip("builds an archive over 4 GiB, set ZIP64_TEST=1")
+	}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/huge.bin" {
+			w.Header().Set("Content-Length", fmt.Sprint(hugeSize))
+			io.Copy(w, io.LimitReader(zeroReader{}, hugeSize))
+			return
+		}
+		w.Write([]byte("after huge file"))
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	queue := internal.NewQueue(3, 10)
+	downloadHandler := internal.NewHandler(queue, internal.NewRateLimiter(1))
+	downloadHandler.SetStorage(internal.NewStorage(dir, time.Hour))
+	ts := httptest.NewServer(newRouter(downloadHandler))
+	defer ts.Close()
+
+	// store: архив получается больше 4 ГиБ, второй файл лежит за границей 32-битных смещений
+	body := fmt.Sprintf(`{"urls": ["%s/huge.bin", "%s/small.txt"], "compression": "store", "manifest": true}`, origin.URL, origin.URL)
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status: %d", resp.StatusCode)
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "huge.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	size, err := io.Copy(sparseWriter{out}, resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	if size <= hugeSize {
+		t.Fatalf("Archive size: %d", size)
+	}
+
+	// временные файлы скачивания и архива удалены
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("Temp files left: %v", files)
+	}
+
+	reader, err := zip.NewReader(out, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.File) != 4 {
+		t.Fatalf("Entries: %d", len(reader.File))
+	}
+
+	huge := reader.File[0]
+	if huge.Name != "huge.bin" || huge.UncompressedSize64 != hugeSize {
+		t.Fatalf("Huge entry: %s %d", huge.Name, huge.UncompressedSize64)
+	}
+	// читаем целиком: zip проверяет CRC в конце файла
+	rc, err := huge.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := io.Copy(io.Discard, rc)
+	rc.Close()
+	if err != nil || read != hugeSize {
+		t.Fatalf("Read huge entry: %d %v", read, err)
+	}
+
+	files := readZipFiles(t, reader, "small.txt", internal.ManifestName)
+	if files["small.txt"] != "after huge file" {
+		t.Fatalf("Small entry: %q", files["small.txt"])
+	}
+	if !strings.Contains(files[internal.ManifestName], fmt.Sprintf(`"size": %d`, hugeSize)) {
+		t.Fatalf("Manifest: %s", files[internal.ManifestName])
+	}
+}
+
+func TestZip64ManyEntries(t *testing.T) {
+	storage := internal.NewStorage(t.TempDir(), time.Hour)
+
+	origin := newOrigin()
+	defer origin.Close()
+
+	ts := newServer(storage)
+	defer ts.Close()
+
+	post(t, ts.URL+"/create", `{"filename": "many"}`)
+
+	// в архиве уже 65535 пустых файлов, счётчик записей в обычном конце архива заполнен
+	file, err := os.Create(storage.Path("many.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipWriter := zip.NewWriter(file)
+	for i := 0; i < 65535; i++ {
+		if _, err := zipWriter.CreateHeader(&zip.FileHeader{Name: fmt.Sprintf("%05d.txt", i), Method: zip.Store}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	body := fmt.Sprintf(`{"filename": "many", "urls": ["%s/a.pdf"]}`, origin.URL)
+	task := waitTask(t, ts.URL, addTask(t, ts.URL+"/add", body))
+	if task.Status != internal.TaskDone {
+		t.Fatalf("Task: %+v", task)
+	}
+
+	data, err := os.ReadFile(storage.Path("many.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// запись Zip64 о конце центрального каталога
+	if !bytes.Contains(data, []byte("PK\x06\x06")) {
+		t.Fatal("No Zip64 end of central directory")
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reader.File) != 65536 {
+		t.Fatalf("Entries: %d", len(reader.File))
+	}
+	if files := readZipFiles(t, reader, "a.pdf"); files["a.pdf"] == "" {
+		t.Fatal("No added file")
+	}
+}
+
+// читаем указанные файлы архива
+func readZipFiles(t *testing.T, reader *zip.Reader, names ...string) map[string]string {
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		for _, name := range names {
+			if f.Name != name {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			files[name] = string(data)
+		}
+	}
+	return files
+}